@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// browserContext returns the context of the headless browser shared by the
+// links of a run, starting it on first use. The browser is sent through Proxy,
+// if it is set.
+func (a *Archiver) browserContext() (context.Context, error) {
+	a.browserMu.Lock()
+	defer a.browserMu.Unlock()
+	if a.browser != nil {
+		return a.browser, nil
+	}
+	opts := chromedp.DefaultExecAllocatorOptions[:]
+	if proxy, _ := browserProxy(a.Proxy); proxy != "" {
+		opts = append(opts, chromedp.ProxyServer(proxy))
+	}
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(context.Background(), opts...)
+	browserCtx, cancelBrowser := chromedp.NewContext(allocCtx)
+	// start the browser before opening tabs, so that closing the first tab
+	// doesn't close the browser
+	if err := chromedp.Run(browserCtx); err != nil {
+		cancelBrowser()
+		cancelAlloc()
+		return nil, err
+	}
+	a.browser = browserCtx
+	a.cancelBrowser = func() {
+		cancelBrowser()
+		cancelAlloc()
+	}
+	return a.browser, nil
+}
+
+// closeBrowser closes the shared headless browser, if it was started.
+func (a *Archiver) closeBrowser() {
+	a.browserMu.Lock()
+	defer a.browserMu.Unlock()
+	if a.browser == nil {
+		return
+	}
+	a.cancelBrowser()
+	a.browser, a.cancelBrowser = nil, nil
+}
+
+// newTab opens a tab in the shared headless browser for loading link, which is
+// closed when ctx is done, after renderTimeout, or when the returned function
+// is called. Unless AllowPrivateIPs is set, every request of the tab is
+// checked with checkHost, including redirects and the resources of the page.
+// Requests are sent with the headers and domain rule configured for their
+// host, and the tab uses the user agent of the rule for link.
+func (a *Archiver) newTab(ctx context.Context, link string) (context.Context, context.CancelFunc, error) {
+	browserCtx, err := a.browserContext()
+	if err != nil {
+		return nil, nil, err
+	}
+	tabCtx, cancelTab := chromedp.NewContext(browserCtx)
+	stop := context.AfterFunc(ctx, cancelTab)
+	tabCtx, cancelTimeout := context.WithTimeout(tabCtx, renderTimeout)
+	cancel := func() {
+		cancelTimeout()
+		stop()
+		cancelTab()
+	}
+
+	var actions []chromedp.Action
+	if rule := a.domainRule(link); rule.UserAgent != "" {
+		actions = append(actions, emulation.SetUserAgentOverride(rule.UserAgent))
+	}
+	_, credentials := browserProxy(a.Proxy)
+	if a.AllowPrivateIPs && credentials == nil && len(a.Headers) == 0 && len(a.DomainRules) == 0 {
+		if err := chromedp.Run(tabCtx, actions...); err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		return tabCtx, cancel, nil
+	}
+	chromedp.ListenTarget(tabCtx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *fetch.EventRequestPaused:
+			// events are handled in order, so respond to them without
+			// blocking the next one
+			go func() {
+				if err := a.checkBrowserRequest(tabCtx, ev.Request.URL); err != nil {
+					chromedp.Run(tabCtx, fetch.FailRequest(ev.RequestID, network.ErrorReasonBlockedByClient))
+					return
+				}
+				continueRequest := fetch.ContinueRequest(ev.RequestID)
+				if headers := a.browserRequestHeaders(ev.Request.URL, ev.Request.Headers); headers != nil {
+					continueRequest = continueRequest.WithHeaders(headers)
+				}
+				chromedp.Run(tabCtx, continueRequest)
+			}()
+		case *fetch.EventAuthRequired:
+			go func() {
+				response := &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseDefault}
+				if ev.AuthChallenge != nil && ev.AuthChallenge.Source == fetch.AuthChallengeSourceProxy && credentials != nil {
+					password, _ := credentials.Password()
+					response = &fetch.AuthChallengeResponse{
+						Response: fetch.AuthChallengeResponseResponseProvideCredentials,
+						Username: credentials.Username(),
+						Password: password,
+					}
+				}
+				chromedp.Run(tabCtx, fetch.ContinueWithAuth(ev.RequestID, response))
+			}()
+		}
+	})
+	actions = append(actions, fetch.Enable().WithHandleAuthRequests(credentials != nil))
+	if err := chromedp.Run(tabCtx, actions...); err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return tabCtx, cancel, nil
+}
+
+// checkBrowserRequest returns ErrPrivateAddress if a request of the headless
+// browser to link would be sent to a private address while AllowPrivateIPs is
+// not set. The browser resolves hosts itself, so unlike requests made by the
+// archiver the check can't cover the address it connects to.
+func (a *Archiver) checkBrowserRequest(ctx context.Context, link string) error {
+	if a.AllowPrivateIPs {
+		return nil
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return err
+	}
+	// data: and blob: URLs don't make a request
+	if u.Hostname() == "" {
+		return nil
+	}
+	return a.checkHost(ctx, u.Hostname())
+}
+
+// browserRequestHeaders returns the headers of a request of the headless
+// browser to link, with the headers and domain rule configured for its host
+// taking precedence over the headers of the browser. It returns nil when none
+// are configured, so that the request is sent unchanged.
+func (a *Archiver) browserRequestHeaders(link string, headers network.Headers) []*fetch.HeaderEntry {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil
+	}
+	configured := a.Headers.forHost(u.Hostname())
+	for k, v := range a.domainRule(link).header() {
+		configured[k] = v
+	}
+	if len(configured) == 0 {
+		return nil
+	}
+	var entries []*fetch.HeaderEntry
+	for name, value := range headers {
+		if _, ok := configured[http.CanonicalHeaderKey(name)]; !ok {
+			entries = append(entries, &fetch.HeaderEntry{Name: name, Value: fmt.Sprint(value)})
+		}
+	}
+	for name, values := range configured {
+		for _, value := range values {
+			entries = append(entries, &fetch.HeaderEntry{Name: name, Value: value})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// browserProxy returns the proxy server of the headless browser for proxy, and
+// the credentials to answer its authentication challenges with. The browser
+// doesn't accept credentials in the proxy URL, and always resolves hosts
+// through SOCKS proxies.
+func browserProxy(proxy *url.URL) (string, *url.Userinfo) {
+	if proxy == nil {
+		return "", nil
+	}
+	scheme := proxy.Scheme
+	if scheme == "socks5h" {
+		scheme = "socks5"
+	}
+	return scheme + "://" + proxy.Host, proxy.User
+}
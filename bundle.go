@@ -0,0 +1,261 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Bundle formats, which are also the extensions of the bundle file.
+const (
+	bundleZip   = "zip"
+	bundleTarGz = "tar.gz"
+)
+
+// bundleName is the name of the bundle file in the output directory, without
+// its extension.
+const bundleName = "archive"
+
+// bundle writes archives into a single zip or tar.gz file rather than as
+// loose files in the output directory. Archives are streamed into a new
+// bundle as they are written. When the bundle is closed, the archives of the
+// previous bundle that weren't replaced are streamed across, and the new
+// bundle replaces the old one, so memory use doesn't grow with the size of
+// the bundle.
+type bundle struct {
+	format   string
+	filePath string
+	// existing are the names of the files in the previous bundle.
+	existing map[string]bool
+	// written are the names of the files written to the new bundle.
+	written map[string]bool
+
+	tmpFile *os.File
+	zw      *zip.Writer
+	gw      *gzip.Writer
+	tw      *tar.Writer
+}
+
+func (a *Archiver) bundlePath() string {
+	return path.Join(a.OutputDir, bundleName+"."+a.Bundle)
+}
+
+// openBundle starts a new bundle, if bundling is enabled.
+func (a *Archiver) openBundle() error {
+	if a.Bundle == "" {
+		return nil
+	}
+	b := &bundle{
+		format:   a.Bundle,
+		filePath: a.bundlePath(),
+		written:  make(map[string]bool),
+	}
+	existing, err := b.readNames()
+	if err != nil {
+		return err
+	}
+	b.existing = existing
+
+	b.tmpFile, err = os.CreateTemp(a.OutputDir, ".bundle-*.tmp")
+	if err != nil {
+		return err
+	}
+	if b.format == bundleZip {
+		b.zw = zip.NewWriter(b.tmpFile)
+	} else {
+		b.gw = gzip.NewWriter(b.tmpFile)
+		b.tw = tar.NewWriter(b.gw)
+	}
+	a.bundle = b
+	return nil
+}
+
+// closeBundle finishes the bundle and replaces the previous bundle with it,
+// or discards it if commit is false.
+func (a *Archiver) closeBundle(commit bool) error {
+	b := a.bundle
+	if b == nil {
+		return nil
+	}
+	a.bundle = nil
+	tmpPath := b.tmpFile.Name()
+	defer os.Remove(tmpPath)
+	if !commit {
+		b.tmpFile.Close()
+		return nil
+	}
+
+	if err := b.copyExisting(); err != nil {
+		b.tmpFile.Close()
+		return err
+	}
+	var err error
+	if b.zw != nil {
+		err = b.zw.Close()
+	} else {
+		err = b.tw.Close()
+		if err == nil {
+			err = b.gw.Close()
+		}
+	}
+	if err == nil {
+		err = b.tmpFile.Chmod(0644)
+	}
+	if err == nil {
+		err = b.tmpFile.Sync()
+	}
+	if closeErr := b.tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, b.filePath)
+}
+
+// bundleFileName returns the name in the bundle of the archive at filePath.
+func (a *Archiver) bundleFileName(filePath string) (string, error) {
+	rel, err := filepath.Rel(a.OutputDir, filePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// has reports whether the bundle holds a file named name.
+func (b *bundle) has(name string) bool {
+	return b.written[name] || b.existing[name]
+}
+
+// add writes a file named name to the bundle.
+func (b *bundle) add(name string, modTime time.Time, content []byte) error {
+	if b.written[name] {
+		return errors.New("duplicate file in bundle: " + name)
+	}
+	b.written[name] = true
+	if b.zw != nil {
+		w, err := b.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	}
+	err := b.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(content)),
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = b.tw.Write(content)
+	return err
+}
+
+// readNames returns the names of the files in the previous bundle, which is
+// empty if there is no previous bundle.
+func (b *bundle) readNames() (map[string]bool, error) {
+	names := make(map[string]bool)
+	err := b.eachExisting(func(name string, copyTo func() error) error {
+		names[name] = true
+		return nil
+	})
+	return names, err
+}
+
+// copyExisting streams the files of the previous bundle that weren't
+// replaced into the new bundle.
+func (b *bundle) copyExisting() error {
+	return b.eachExisting(func(name string, copyTo func() error) error {
+		if b.written[name] {
+			return nil
+		}
+		return copyTo()
+	})
+}
+
+// eachExisting calls fn for each file in the previous bundle, with a function
+// that copies the file into the new bundle.
+func (b *bundle) eachExisting(fn func(name string, copyTo func() error) error) error {
+	if b.format == bundleZip {
+		zr, err := zip.OpenReader(b.filePath)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			f := f
+			err := fn(f.Name, func() error {
+				// copy the compressed data as is
+				return b.zw.Copy(f)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	file, err := os.Open(b.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	gr, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		err = fn(header.Name, func() error {
+			if err := b.tw.WriteHeader(header); err != nil {
+				return err
+			}
+			_, err := io.Copy(b.tw, tr)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// isArchived reports whether there is an archive at filePath, looking in the
+// bundle when bundling.
+func (a *Archiver) isArchived(filePath string) bool {
+	if a.bundle != nil {
+		name, err := a.bundleFileName(filePath)
+		return err == nil && a.bundle.has(name)
+	}
+	_, err := os.Stat(filePath)
+	return !os.IsNotExist(err)
+}
+
+// addToBundle writes the archive at filePath to the bundle.
+func (a *Archiver) addToBundle(filePath string, modTime time.Time, content string) error {
+	name, err := a.bundleFileName(filePath)
+	if err != nil {
+		return err
+	}
+	return a.bundle.add(name, modTime, []byte(content))
+}
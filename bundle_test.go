@@ -0,0 +1,147 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+// readBundle returns the contents of the files in the bundle at filePath.
+func readBundle(t *testing.T, format, filePath string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	if format == bundleZip {
+		zr, err := zip.OpenReader(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := files[f.Name]; ok {
+				t.Errorf("expected %+v once in bundle", f.Name)
+			}
+			files[f.Name] = string(b)
+		}
+		return files
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := files[header.Name]; ok {
+			t.Errorf("expected %+v once in bundle", header.Name)
+		}
+		files[header.Name] = string(b)
+	}
+}
+
+func TestArchiveBundle(t *testing.T) {
+	for _, format := range []string{bundleZip, bundleTarGz} {
+		format := format
+		t.Run(format, func(t *testing.T) {
+			inputDir := t.TempDir()
+			outputDir := t.TempDir()
+			notesPath := filepath.Join(inputDir, "notes.md")
+			err := os.WriteFile(notesPath, []byte(" [a](https://example.com/a)\n"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content := "<p>first</p>"
+			a := &Archiver{
+				InputDir:  inputDir,
+				OutputDir: outputDir,
+				Bundle:    format,
+				Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+					return readability.Article{Title: "Example", Content: content}, nil
+				}),
+			}
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+
+			// archives from earlier runs should be kept in the bundle
+			err = os.WriteFile(notesPath, []byte(" [a](https://example.com/a)\n [b](https://example.com/b)\n"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+
+			// refreshed archives should replace their previous copy
+			content = "<p>second</p>"
+			a.Refresh = true
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+
+			files := readBundle(t, format, filepath.Join(outputDir, "archive."+format))
+			var names []string
+			for name := range files {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			var expected []string
+			for _, link := range []string{"https://example.com/a", "https://example.com/b"} {
+				linkID, err := getLinkID(link, defaultMaxIDLength, defaultHashLength)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected = append(expected, linkID+"/index.html")
+			}
+			if !reflect.DeepEqual(names, expected) {
+				t.Fatalf("expected %+v, got %+v", expected, names)
+			}
+			for _, name := range names {
+				if !strings.HasPrefix(files[name], "---\nurl: ") || !strings.HasSuffix(files[name], "<p>second</p>") {
+					t.Errorf("(%+v): expected refreshed archive with metadata, got %+v", name, files[name])
+				}
+			}
+
+			// no loose archives should be written
+			entries, err := filepath.Glob(filepath.Join(outputDir, "*", "index.html"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("expected no loose archives, got %+v", entries)
+			}
+		})
+	}
+}
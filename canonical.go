@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
+)
+
+// extractCanonicalURL returns the URL declared by page in a
+// <link rel="canonical"> tag, resolved against base, the URL the page was
+// fetched from.
+func extractCanonicalURL(page string, base *url.URL) string {
+	z := html.NewTokenizer(strings.NewReader(page))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			if token.Data == "body" {
+				// the canonical link belongs in the head
+				return ""
+			}
+			if token.Data != "link" {
+				continue
+			}
+			var rel, href string
+			for _, attr := range token.Attr {
+				switch attr.Key {
+				case "rel":
+					rel = attr.Val
+				case "href":
+					href = strings.TrimSpace(attr.Val)
+				}
+			}
+			if href == "" || !hasLinkRel(rel, "canonical") {
+				continue
+			}
+			u, err := base.Parse(href)
+			if err != nil {
+				return ""
+			}
+			return u.String()
+		}
+	}
+}
+
+// hasLinkRel reports whether rel, a space-separated list of link types,
+// contains linkType.
+func hasLinkRel(rel, linkType string) bool {
+	for _, t := range strings.Fields(rel) {
+		if strings.EqualFold(t, linkType) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalLink returns the link to archive in place of link, given the
+// canonical URL declared by its page, and reports whether it should be used.
+// Canonical URLs are compared to link once both are normalized, and are only
+// used when they differ and are on the same site, so that a page can't
+// redirect its archive to something unrelated.
+func (a *Archiver) canonicalLink(link, canonicalURL string) (string, bool) {
+	if canonicalURL == "" {
+		return "", false
+	}
+	canonical, err := a.normalizeLink(canonicalURL)
+	if err != nil {
+		return "", false
+	}
+	if normalized, _ := a.normalizeLink(link); canonical == normalized {
+		return "", false
+	}
+	u, err := url.Parse(canonicalURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	original, err := url.Parse(link)
+	if err != nil || !isSameSite(original.Hostname(), u.Hostname()) {
+		return "", false
+	}
+	return canonicalURL, true
+}
+
+// isSameSite reports whether two hosts belong to the same registrable domain,
+// such as amp.example.com and www.example.com. Hosts without one, such as IP
+// addresses, must match exactly.
+func isSameSite(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if a == b {
+		return true
+	}
+	siteA, err := publicsuffix.EffectiveTLDPlusOne(a)
+	if err != nil {
+		return false
+	}
+	siteB, err := publicsuffix.EffectiveTLDPlusOne(b)
+	return err == nil && siteA == siteB
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractCanonicalURL(t *testing.T) {
+	base, err := url.Parse("https://amp.example.com/amp/post")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name     string
+		page     string
+		expected string
+	}{
+		{
+			"absolute",
+			`<html><head><link rel="canonical" href="https://example.com/post"></head></html>`,
+			"https://example.com/post",
+		},
+		{
+			"relative",
+			`<html><head><link rel="canonical" href="/post"/></head></html>`,
+			"https://amp.example.com/post",
+		},
+		{
+			"multiple link types",
+			`<html><head><link rel="amphtml" href="/amp/post"><link href="/post" rel="Canonical home"></head></html>`,
+			"https://amp.example.com/post",
+		},
+		{
+			"none",
+			`<html><head><link rel="icon" href="/favicon.ico"></head></html>`,
+			"",
+		},
+		{
+			"in body",
+			`<html><head></head><body><link rel="canonical" href="/post"></body></html>`,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if result := extractCanonicalURL(tt.page, base); result != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCanonicalLink(t *testing.T) {
+	var tests = []struct {
+		name      string
+		link      string
+		canonical string
+		expected  string
+	}{
+		{"amp subdomain", "https://amp.example.com/post", "https://www.example.com/post", "https://www.example.com/post"},
+		{"tracking parameters", "https://example.com/post?utm_source=feed", "https://example.com/post", "https://example.com/post"},
+		{"same", "https://example.com/post", "https://example.com/post", ""},
+		{"off domain", "https://example.com/post", "https://example.org/post", ""},
+		{"suffix of another domain", "https://example.com/post", "https://notexample.com/post", ""},
+		{"not http", "https://example.com/post", "ftp://example.com/post", ""},
+		{"none", "https://example.com/post", "", ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Archiver{}
+			result, ok := a.canonicalLink(tt.link, tt.canonical)
+			if ok != (tt.expected != "") || result != tt.expected {
+				t.Errorf("expected %q, got %q (%t)", tt.expected, result, ok)
+			}
+		})
+	}
+}
+
+func TestArchiveCanonical(t *testing.T) {
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = append(fetched, r.URL.Path)
+		var canonical string
+		switch r.URL.Path {
+		case "/amp/article":
+			canonical = "/article"
+		case "/unrelated":
+			canonical = "https://unrelated.example.com/"
+		}
+		page := testArticleHTML
+		if canonical != "" {
+			page = strings.Replace(page, "<head>", `<head><link rel="canonical" href="`+canonical+`">`, 1)
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	ampLink := server.URL + "/amp/article"
+	articleLink := server.URL + "/article"
+	unrelatedLink := server.URL + "/unrelated"
+	markdown := " [amp](" + ampLink + ")\n [article](" + articleLink + ")\n [unrelated](" + unrelatedLink + ")\n"
+	if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{
+		InputDir:        inputDir,
+		OutputDir:       outputDir,
+		UseCanonical:    true,
+		AllowLocal:      true,
+		AllowPrivateIPs: true,
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+
+	// the article was archived from its AMP page, so it isn't fetched again
+	if strings.Join(fetched, ",") != "/amp/article,/unrelated" {
+		t.Errorf("expected only the AMP page and unrelated page to be fetched, got %+v", fetched)
+	}
+	archives, err := a.listArchives()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 2 {
+		t.Fatalf("expected 2 archives, got %+v", archives)
+	}
+	for link, requested := range map[string]string{articleLink: ampLink, unrelatedLink: ""} {
+		linkID, err := getLinkID(link, defaultMaxIDLength, defaultHashLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metadata, err := readMetadata(archivePath(outputDir, linkID, formatHTML))
+		if err != nil {
+			t.Fatalf("expected archive of %s, got %+v", link, err)
+		}
+		if metadata.URL != link || metadata.RequestedURL != requested {
+			t.Errorf("expected archive of %s requested as %q, got %+v", link, requested, metadata)
+		}
+	}
+
+	// the canonical archive isn't orphaned while only the AMP page links
+	// to it
+	markdown = " [amp](" + ampLink + ")\n"
+	if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644); err != nil {
+		t.Fatal(err)
+	}
+	orphaned, err := a.Prune(false)
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	unrelatedID, err := getLinkID(unrelatedLink, defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphaned) != 1 || orphaned[0] != unrelatedID {
+		t.Errorf("expected only %s to be orphaned, got %+v", unrelatedID, orphaned)
+	}
+}
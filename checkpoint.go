@@ -0,0 +1,31 @@
+package main
+
+import "time"
+
+// startCheckpoints starts counting the links archived towards the next
+// checkpoint.
+func (a *Archiver) startCheckpoints() {
+	a.uncheckpointedArchives = 0
+	a.lastCheckpoint = time.Now()
+}
+
+// checkpoint writes the caches if CheckpointArchives links have been
+// archived, or CheckpointInterval has passed, since they were last written,
+// so that a run that is killed keeps most of its progress. Bundled runs
+// aren't checkpointed, since the archives of a bundle are lost if it isn't
+// closed.
+func (a *Archiver) checkpoint() error {
+	if a.uncheckpointedArchives == 0 || a.bundle != nil {
+		return nil
+	}
+	archivesDue := a.CheckpointArchives > 0 && a.uncheckpointedArchives >= a.CheckpointArchives
+	intervalDue := a.CheckpointInterval > 0 && time.Since(a.lastCheckpoint) >= a.CheckpointInterval
+	if !archivesDue && !intervalDue {
+		return nil
+	}
+	if err := a.writeCaches(); err != nil {
+		return err
+	}
+	a.startCheckpoints()
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestArchiveCheckpoint(t *testing.T) {
+	var tests = []struct {
+		name     string
+		archives int
+		interval time.Duration
+		bundle   string
+		// expected are the numbers of links in the checked link cache
+		// when each link is fetched
+		expected []int
+	}{
+		{"no checkpoints", 0, 0, "", []int{0, 0, 0, 0}},
+		{"every link", 1, 0, "", []int{0, 1, 2, 3}},
+		{"every 2 links", 2, 0, "", []int{0, 0, 2, 2}},
+		{"interval", 0, time.Nanosecond, "", []int{0, 1, 2, 3}},
+		{"bundle", 1, 0, bundleZip, []int{0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			outputDir := t.TempDir()
+			markdown := " [a](https://example.com/a)\n [b](https://example.com/b)\n [c](https://example.com/c)\n [d](https://example.com/d)\n"
+			if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var checked []int
+			a := &Archiver{
+				InputDir:           inputDir,
+				OutputDir:          outputDir,
+				CheckpointArchives: tt.archives,
+				CheckpointInterval: tt.interval,
+				Bundle:             tt.bundle,
+				Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+					b, err := os.ReadFile(filepath.Join(outputDir, checkedLinksFile))
+					if err != nil && !os.IsNotExist(err) {
+						t.Fatal(err)
+					}
+					checked = append(checked, len(strings.Fields(string(b))))
+					return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+				}),
+				stdout: &bytes.Buffer{},
+			}
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			if len(checked) != len(tt.expected) {
+				t.Fatalf("expected %d fetches, got %+v", len(tt.expected), checked)
+			}
+			for i := range checked {
+				if checked[i] != tt.expected[i] {
+					t.Errorf("expected %+v links checked at each fetch, got %+v", tt.expected, checked)
+					break
+				}
+			}
+		})
+	}
+}
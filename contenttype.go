@@ -0,0 +1,27 @@
+package main
+
+import (
+	"mime"
+	"strings"
+)
+
+// matchesContentType reports whether the media type of contentType, a
+// Content-Type header, matches any of the given patterns. A pattern is a
+// media type such as application/json, or a type with a `*` subtype, such as
+// application/*, and is matched case-insensitively.
+func matchesContentType(contentType string, patterns []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" || pattern == "*/*" || pattern == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
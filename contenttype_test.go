@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchesContentType(t *testing.T) {
+	var tests = []struct {
+		contentType string
+		patterns    []string
+		expected    bool
+	}{
+		{"application/json", []string{"application/json"}, true},
+		{"application/json; charset=utf-8", []string{"application/json"}, true},
+		{"Application/JSON", []string{"application/json"}, true},
+		{"application/json", []string{"APPLICATION/*"}, true},
+		{"application/vnd.api+json", []string{"image/*", "application/*"}, true},
+		{"text/html", []string{"*/*"}, true},
+		{"text/html; charset=utf-8", []string{"application/*"}, false},
+		{"application/json", []string{"application/xml"}, false},
+		{"applications/json", []string{"application/*"}, false},
+		{"application/json", nil, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.contentType, func(t *testing.T) {
+			if result := matchesContentType(tt.contentType, tt.patterns); result != tt.expected {
+				t.Errorf("(%+v, %+v): expected %+v, got %+v", tt.contentType, tt.patterns, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestArchiveSkipContentTypes(t *testing.T) {
+	contentTypes := map[string]string{
+		"/article": "text/html; charset=utf-8",
+		"/api":     "application/json",
+		"/feed":    "application/rss+xml",
+		"/image":   "image/png",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentTypes[r.URL.Path])
+		if r.URL.Path == "/article" {
+			w.Write([]byte(testArticleHTML))
+		} else {
+			w.Write([]byte("{}"))
+		}
+	}))
+	defer server.Close()
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	markdown := ""
+	for _, p := range []string{"/article", "/api", "/feed", "/image"} {
+		markdown += " [link](" + server.URL + p + ")\n"
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{
+		InputDir:         inputDir,
+		OutputDir:        outputDir,
+		SkipContentTypes: []string{"application/*"},
+		AllowLocal:       true,
+		AllowPrivateIPs:  true,
+		stdout:           &bytes.Buffer{},
+		stderr:           &bytes.Buffer{},
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+
+	for p, expected := range map[string]bool{"/article": true, "/api": false, "/feed": false, "/image": false} {
+		linkID, err := getLinkID(server.URL+p, defaultMaxIDLength, defaultHashLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = os.Stat(archivePath(outputDir, linkID, formatHTML))
+		if archived := err == nil; archived != expected {
+			t.Errorf("%s: expected archived %+v, got %+v", p, expected, archived)
+		}
+		if !a.checkedLinks[linkID] {
+			t.Errorf("%s: expected link to be checked", p)
+		}
+	}
+	// only the image, which isn't HTML but wasn't skipped, failed
+	if failed := a.metrics.failureCount(); failed != 1 {
+		t.Errorf("expected 1 failure, got %d", failed)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// debugLogFile is the name of the fetch log written to link directories when
+// DebugLogs is set.
+const debugLogFile = "archive.log"
+
+// fetchLog records an attempt to fetch a link.
+type fetchLog struct {
+	StartedAt     time.Time
+	Duration      time.Duration
+	CaptureMethod string
+	// URL is the URL the page was fetched from, after redirects.
+	URL        string
+	StatusCode int
+	Err        error
+}
+
+// String formats the attempt as a line of space-separated key=value pairs.
+func (l fetchLog) String() string {
+	fields := []string{
+		"started=" + l.StartedAt.UTC().Format(time.RFC3339Nano),
+		"duration=" + l.Duration.String(),
+		"method=" + l.CaptureMethod,
+	}
+	if l.URL != "" {
+		fields = append(fields, "url="+l.URL)
+	}
+	if l.StatusCode != 0 {
+		fields = append(fields, "status="+strconv.Itoa(l.StatusCode))
+	}
+	if l.Err != nil {
+		fields = append(fields, "error="+strconv.Quote(l.Err.Error()))
+	}
+	return strings.Join(fields, " ")
+}
+
+// logFetch appends entry to the fetch log in the link directory of linkID,
+// and reports whether it was written. Nothing is written unless DebugLogs is
+// set. A successful fetch of a link archived for the first time isn't written
+// until its link directory exists, while a failed one creates the directory,
+// since those are the fetches the log is for. A directory holding only the
+// log isn't taken for an archive. Single-file archives and bundles have no
+// link directories, so they are never logged.
+func (a *Archiver) logFetch(linkID string, entry fetchLog) bool {
+	if !a.DebugLogs || a.Format == formatSingleFile || a.bundle != nil {
+		return false
+	}
+	linkDir := path.Join(a.OutputDir, linkID)
+	if _, err := os.Stat(linkDir); err != nil && entry.Err == nil {
+		return false
+	}
+	openFiles <- struct{}{}
+	defer func() { <-openFiles }()
+	err := os.MkdirAll(linkDir, 0755)
+	var f *os.File
+	if err == nil {
+		f, err = os.OpenFile(path.Join(linkDir, debugLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	if err == nil {
+		_, err = fmt.Fprintln(f, entry)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		a.logf(logEvent{Event: eventWarning, LinkID: linkID, Err: err}, "cannot write %s for %+v: %+v", debugLogFile, linkID, err)
+	}
+	return true
+}
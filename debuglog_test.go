@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchLogString(t *testing.T) {
+	var tests = []struct {
+		name     string
+		entry    fetchLog
+		expected string
+	}{
+		{
+			"fetched",
+			fetchLog{
+				StartedAt:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				Duration:      1500 * time.Millisecond,
+				CaptureMethod: captureMethodFetch,
+				URL:           "https://example.com/new",
+				StatusCode:    http.StatusOK,
+			},
+			"started=2024-01-02T03:04:05Z duration=1.5s method=fetch url=https://example.com/new status=200",
+		},
+		{
+			"failed",
+			fetchLog{
+				StartedAt:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				Duration:      time.Second,
+				CaptureMethod: captureMethodFetch,
+				Err:           errors.New(`failed to fetch the page: "timeout"`),
+			},
+			`started=2024-01-02T03:04:05Z duration=1s method=fetch error="failed to fetch the page: \"timeout\""`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.entry.String(); result != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestArchiveDebugLogs(t *testing.T) {
+	var tests = []struct {
+		name      string
+		debugLogs bool
+	}{
+		{"disabled", false},
+		{"enabled", true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			failing := false
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/old" {
+					http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+					return
+				}
+				if failing {
+					http.Error(w, "unavailable", http.StatusServiceUnavailable)
+					return
+				}
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte(testArticleHTML))
+			}))
+			defer server.Close()
+
+			inputDir := t.TempDir()
+			outputDir := t.TempDir()
+			link := server.URL + "/old"
+			if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [old]("+link+")\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			a := &Archiver{
+				InputDir:        inputDir,
+				OutputDir:       outputDir,
+				AllowLocal:      true,
+				AllowPrivateIPs: true,
+				DebugLogs:       tt.debugLogs,
+			}
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			// a failed refresh of the archive is logged too
+			failing = true
+			a.Refresh = true
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+
+			linkID, err := getLinkID(link, defaultMaxIDLength, defaultHashLength)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(filepath.Join(outputDir, linkID, debugLogFile))
+			if !tt.debugLogs {
+				if !os.IsNotExist(err) {
+					t.Errorf("expected no %s, got %+v", debugLogFile, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("expected 2 log lines, got %q", b)
+			}
+			for _, expected := range []string{"method=fetch", "url=" + server.URL + "/new", "status=200"} {
+				if !strings.Contains(lines[0], expected) {
+					t.Errorf("expected %q in first log line, got %q", expected, lines[0])
+				}
+			}
+			if !strings.Contains(lines[1], "error=") {
+				t.Errorf("expected error in second log line, got %q", lines[1])
+			}
+		})
+	}
+}
+
+func TestArchiveDebugLogsFirstFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	link := server.URL + "/abc"
+	if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc]("+link+")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{
+		InputDir:        inputDir,
+		OutputDir:       outputDir,
+		AllowLocal:      true,
+		AllowPrivateIPs: true,
+		DebugLogs:       true,
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+
+	linkID, err := getLinkID(link, defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(outputDir, linkID, debugLogFile))
+	if err != nil {
+		t.Fatalf("expected the failed fetch to be logged, got %+v", err)
+	}
+	if !strings.Contains(string(b), "error=") {
+		t.Errorf("expected error in log line, got %q", b)
+	}
+	// the directory holding the log isn't an archive
+	if a.hasLinkArchive(linkID) {
+		t.Errorf("expected link directory with only a log to not be an archive")
+	}
+}
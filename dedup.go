@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"path/filepath"
+)
+
+// initContentHashes indexes the content hashes of the archives in the output
+// directory, so that links whose content matches an existing archive can be
+// recorded as duplicates of it. Archives that are themselves duplicates are
+// not indexed.
+func (a *Archiver) initContentHashes() error {
+	a.contentHashes = make(map[string]string)
+	if !a.DedupeContent {
+		return nil
+	}
+	archives, err := a.listArchives()
+	if err != nil {
+		return err
+	}
+	for linkID, entryPath := range archives {
+		isDir := entryPath != archivePath(a.OutputDir, linkID, formatSingleFile)
+		_, metadata, err := archiveFile(a.OutputDir, linkID, isDir)
+		if err != nil || metadata.ContentHash == "" || metadata.DuplicateOf != "" {
+			continue
+		}
+		a.contentHashes[metadata.ContentHash] = linkID
+	}
+	return nil
+}
+
+// duplicateOf returns the ID of the archive that already holds content with
+// the given hash, if it isn't the archive of linkID itself.
+func (a *Archiver) duplicateOf(contentHash, linkID string) (string, bool) {
+	if !a.DedupeContent {
+		return "", false
+	}
+	canonicalID, ok := a.contentHashes[contentHash]
+	if !ok || canonicalID == linkID {
+		return "", false
+	}
+	return canonicalID, true
+}
+
+func (a *Archiver) setContentHash(contentHash, linkID string) {
+	if a.DedupeContent && a.contentHashes != nil {
+		if _, ok := a.contentHashes[contentHash]; !ok {
+			a.contentHashes[contentHash] = linkID
+		}
+	}
+}
+
+// duplicateBody returns the body of a pointer archive written to filePath,
+// referencing the archive of canonicalID instead of storing its content again.
+func (a *Archiver) duplicateBody(filePath, canonicalID string) (string, error) {
+	target, err := filepath.Rel(filepath.Dir(filePath), a.currentArchivePath(canonicalID))
+	if err != nil {
+		return "", err
+	}
+	target = filepath.ToSlash(target)
+	if a.Format == formatMarkdown {
+		return fmt.Sprintf("Duplicate of [%s](%s)\n", canonicalID, target), nil
+	}
+	target = html.EscapeString(target)
+	return fmt.Sprintf("<meta http-equiv=\"refresh\" content=\"0; url=%s\">\n<p>Duplicate of <a href=\"%s\">%s</a></p>\n", target, target, html.EscapeString(canonicalID)), nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestArchiveDedupeContent(t *testing.T) {
+	var tests = []struct {
+		name   string
+		format string
+		body   string
+	}{
+		{"html", formatHTML, `<meta http-equiv="refresh" content="0; url=../example.com__a_`},
+		{"markdown", formatMarkdown, "(../example.com__a_"},
+		{"singlefile", formatSingleFile, `<meta http-equiv="refresh" content="0; url=example.com__a_`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			outputDir := t.TempDir()
+			markdown := " [a](https://example.com/a)\n [b](https://example.com/b)\n [c](https://example.com/c)\n"
+			err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := &Archiver{
+				InputDir:      inputDir,
+				OutputDir:     outputDir,
+				Format:        tt.format,
+				DedupeContent: true,
+				Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+					if link == "https://example.com/c" {
+						return readability.Article{Title: "Other", Content: "<p>other</p>"}, nil
+					}
+					return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+				}),
+			}
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+
+			ids := make(map[string]string)
+			for _, link := range []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"} {
+				linkID, err := getLinkID(link, defaultMaxIDLength, defaultHashLength)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids[link] = linkID
+			}
+			duplicatePath := archivePath(outputDir, ids["https://example.com/b"], tt.format)
+			metadata, err := readMetadata(duplicatePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if metadata.DuplicateOf != ids["https://example.com/a"] {
+				t.Errorf("expected duplicate of %+v, got %+v", ids["https://example.com/a"], metadata.DuplicateOf)
+			}
+			b, err := os.ReadFile(duplicatePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(b), tt.body) || strings.Contains(string(b), "<p>abc</p>") {
+				t.Errorf("expected pointer to canonical archive, got %+v", string(b))
+			}
+
+			for _, link := range []string{"https://example.com/a", "https://example.com/c"} {
+				metadata, err := readMetadata(archivePath(outputDir, ids[link], tt.format))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if metadata.DuplicateOf != "" {
+					t.Errorf("(%+v): expected no duplicate, got %+v", link, metadata.DuplicateOf)
+				}
+			}
+		})
+	}
+}
+
+func TestArchiveDedupeContentExistingArchive(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	notesPath := filepath.Join(inputDir, "notes.md")
+	err := os.WriteFile(notesPath, []byte(" [a](https://example.com/a)\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{
+		InputDir:      inputDir,
+		OutputDir:     outputDir,
+		DedupeContent: true,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+
+	// a later run should dedupe against archives from earlier runs
+	err = os.WriteFile(notesPath, []byte(" [a](https://example.com/a)\n [b](https://example.com/b)\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	canonicalID, err := getLinkID("https://example.com/a", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	duplicateID, err := getLinkID("https://example.com/b", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := readMetadata(archivePath(outputDir, duplicateID, formatHTML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.DuplicateOf != canonicalID {
+		t.Errorf("expected duplicate of %+v, got %+v", canonicalID, metadata.DuplicateOf)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DomainRules maps a domain to overrides of how links on that domain and its
+// subdomains are fetched, e.g.
+//
+//	example.com:
+//	  timeout: 30s
+//	  user_agent: Mozilla/5.0
+//	  render_js: true
+//	  headers:
+//	    Cookie: session=abc
+type DomainRules map[string]DomainRule
+
+// DomainRule overrides how links on a domain are fetched. Unset fields keep
+// the behavior of less specific domains, or the defaults.
+type DomainRule struct {
+	// Timeout bounds each fetch, in place of fetchTimeout.
+	Timeout ruleDuration `yaml:"timeout,omitempty"`
+	// UserAgent is sent as the User-Agent header.
+	UserAgent string `yaml:"user_agent,omitempty"`
+	// RenderJS, if set, overrides Archiver.RenderJS.
+	RenderJS *bool `yaml:"render_js,omitempty"`
+	// Headers are additional HTTP headers, which take precedence over
+	// Archiver.Headers.
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+// ruleDuration is a time.Duration written as a string in YAML, e.g. 30s.
+type ruleDuration time.Duration
+
+func (d *ruleDuration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if parsed <= 0 {
+		return fmt.Errorf("timeout must be positive, got %s", s)
+	}
+	*d = ruleDuration(parsed)
+	return nil
+}
+
+func (d ruleDuration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// loadDomainRules reads DomainRules from a YAML file. An empty path returns no
+// rules.
+func loadDomainRules(filePath string) (DomainRules, error) {
+	if filePath == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var rules DomainRules
+	err = yaml.UnmarshalStrict(b, &rules)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// forHost returns the rule for host. When several domains match, each field
+// is taken from the most specific domain that sets it.
+func (r DomainRules) forHost(host string) DomainRule {
+	host = strings.ToLower(host)
+	var domains []string
+	for domain := range r {
+		if matchesDomain(host, strings.ToLower(domain)) {
+			domains = append(domains, domain)
+		}
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		return len(domains[i]) < len(domains[j])
+	})
+
+	var rule DomainRule
+	for _, domain := range domains {
+		override := r[domain]
+		if override.Timeout != 0 {
+			rule.Timeout = override.Timeout
+		}
+		if override.UserAgent != "" {
+			rule.UserAgent = override.UserAgent
+		}
+		if override.RenderJS != nil {
+			rule.RenderJS = override.RenderJS
+		}
+		for k, v := range override.Headers {
+			if rule.Headers == nil {
+				rule.Headers = make(map[string]string)
+			}
+			rule.Headers[k] = v
+		}
+	}
+	return rule
+}
+
+// header returns the headers to send with a request following the rule.
+func (r DomainRule) header() http.Header {
+	header := http.Header{}
+	for k, v := range r.Headers {
+		header.Set(k, v)
+	}
+	if r.UserAgent != "" {
+		header.Set("User-Agent", r.UserAgent)
+	}
+	return header
+}
+
+// domainRule returns the rule for the host of link.
+func (a *Archiver) domainRule(link string) DomainRule {
+	if len(a.DomainRules) == 0 {
+		return DomainRule{}
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return DomainRule{}
+	}
+	return a.DomainRules.forHost(u.Hostname())
+}
+
+// renderJS reports whether link is rendered in a headless browser when a
+// plain fetch fails or captures too little content.
+func (a *Archiver) renderJS(link string) bool {
+	if rule := a.domainRule(link); rule.RenderJS != nil {
+		return *rule.RenderJS
+	}
+	return a.RenderJS
+}
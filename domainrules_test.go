@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDomainRulesForHost(t *testing.T) {
+	enabled, disabled := true, false
+	rules := DomainRules{
+		"example.com": {
+			Timeout:   ruleDuration(30 * time.Second),
+			UserAgent: "parent",
+			RenderJS:  &enabled,
+			Headers:   map[string]string{"Cookie": "session=parent", "Accept-Language": "en"},
+		},
+		"blog.example.com": {
+			UserAgent: "child",
+			RenderJS:  &disabled,
+			Headers:   map[string]string{"Cookie": "session=child"},
+		},
+		"example.org": {
+			Timeout: ruleDuration(time.Minute),
+		},
+	}
+	var tests = []struct {
+		name     string
+		host     string
+		expected DomainRule
+	}{
+		{
+			"exact domain",
+			"example.com",
+			rules["example.com"],
+		},
+		{
+			"most specific domain wins",
+			"www.blog.example.com",
+			DomainRule{
+				Timeout:   ruleDuration(30 * time.Second),
+				UserAgent: "child",
+				RenderJS:  &disabled,
+				Headers:   map[string]string{"Cookie": "session=child", "Accept-Language": "en"},
+			},
+		},
+		{
+			"case insensitive",
+			"EXAMPLE.org",
+			rules["example.org"],
+		},
+		{
+			"suffix is not a subdomain",
+			"notexample.com",
+			DomainRule{},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			result := rules.forHost(tt.host)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("(%+v): expected %+v, got %+v", tt.host, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestLoadDomainRules(t *testing.T) {
+	var tests = []struct {
+		name      string
+		content   string
+		expectErr bool
+	}{
+		{"valid", "example.com:\n  timeout: 30s\n  user_agent: archiver\n  render_js: false\n  headers:\n    Cookie: session=abc\n", false},
+		{"invalid timeout", "example.com:\n  timeout: 30\n", true},
+		{"unknown field", "example.com:\n  render: true\n", true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "rules.yaml")
+			if err := os.WriteFile(filePath, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			rules, err := loadDomainRules(filePath)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got %+v", rules)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			disabled := false
+			expected := DomainRules{"example.com": {
+				Timeout:   ruleDuration(30 * time.Second),
+				UserAgent: "archiver",
+				RenderJS:  &disabled,
+				Headers:   map[string]string{"Cookie": "session=abc"},
+			}}
+			if !reflect.DeepEqual(rules, expected) {
+				t.Errorf("expected %+v, got %+v", expected, rules)
+			}
+		})
+	}
+}
+
+func TestFetchFromURLDomainRules(t *testing.T) {
+	var userAgent, cookie string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		userAgent = r.Header.Get("User-Agent")
+		cookie = r.Header.Get("Cookie")
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(testArticleHTML))
+	}))
+	defer server.Close()
+
+	a := &Archiver{
+		AllowPrivateIPs: true,
+		Headers:         HostHeaders{"127.0.0.1": {"Cookie": "session=headers", "User-Agent": "headers"}},
+		DomainRules: DomainRules{"127.0.0.1": {
+			Timeout:   ruleDuration(50 * time.Millisecond),
+			UserAgent: "rule",
+			Headers:   map[string]string{"Cookie": "session=rule"},
+		}},
+	}
+	if _, err := a.fetchFromURL(context.Background(), server.URL+"/fast", cacheValidators{}); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if userAgent != "rule" || cookie != "session=rule" {
+		t.Errorf("expected the rule's headers to take precedence, got User-Agent %q and Cookie %q", userAgent, cookie)
+	}
+	if _, err := a.fetchFromURL(context.Background(), server.URL+"/slow", cacheValidators{}); err == nil {
+		t.Errorf("expected the rule's timeout to be exceeded, got nil error")
+	}
+}
+
+func TestArchiverRenderJS(t *testing.T) {
+	enabled, disabled := true, false
+	a := &Archiver{
+		RenderJS: true,
+		DomainRules: DomainRules{
+			"static.example.com": {RenderJS: &disabled},
+			"example.org":        {RenderJS: &enabled},
+		},
+	}
+	var tests = []struct {
+		link     string
+		renderJS bool
+		expected bool
+	}{
+		{"https://example.com/a", true, true},
+		{"https://static.example.com/a", true, false},
+		{"https://example.org/a", false, true},
+		{"https://example.net/a", false, false},
+	}
+	for _, tt := range tests {
+		a.RenderJS = tt.renderJS
+		if result := a.renderJS(tt.link); result != tt.expected {
+			t.Errorf("(%+v, RenderJS %t): expected %t, got %t", tt.link, tt.renderJS, tt.expected, result)
+		}
+	}
+}
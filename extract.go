@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-shiori/go-readability"
+	"golang.org/x/net/html"
+)
+
+// Extractors of the content of fetched pages.
+const (
+	extractorReadability = "readability"
+	extractorRaw         = "raw"
+	extractorNone        = "none"
+)
+
+// ErrUnsupportedExtractor is returned for an unknown -extractor.
+var ErrUnsupportedExtractor = errors.New("unsupported extractor")
+
+// Extractor extracts the article from the HTML of a page fetched from
+// pageURL.
+type Extractor interface {
+	Extract(page string, pageURL *url.URL) (readability.Article, error)
+}
+
+// ExtractorFunc adapts a function to an Extractor.
+type ExtractorFunc func(page string, pageURL *url.URL) (readability.Article, error)
+
+// Extract calls f(page, pageURL).
+func (f ExtractorFunc) Extract(page string, pageURL *url.URL) (readability.Article, error) {
+	return f(page, pageURL)
+}
+
+// newExtractor returns the extractor with the given name:
+//
+//   - readability extracts the main article of the page with go-readability
+//   - raw keeps the whole body of the page, without scripts and styles, and
+//     with links resolved against the page's URL
+//   - none keeps the body of the page as it was fetched
+func newExtractor(name string) (Extractor, error) {
+	switch name {
+	case extractorReadability:
+		return ExtractorFunc(extractReadability), nil
+	case extractorRaw:
+		return ExtractorFunc(extractRaw), nil
+	case extractorNone:
+		return ExtractorFunc(extractNone), nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedExtractor, name)
+	}
+}
+
+// extract applies Extractor, or readability if it isn't set, to page, then
+// overrides the extracted metadata with the metadata the page declares.
+func (a *Archiver) extract(page string, pageURL *url.URL) (readability.Article, error) {
+	extractor := a.Extractor
+	if extractor == nil {
+		extractor = ExtractorFunc(extractReadability)
+	}
+	article, err := extractor.Extract(page, pageURL)
+	if err != nil {
+		return readability.Article{}, err
+	}
+	applyPageMetadata(&article, page)
+	return article, nil
+}
+
+// extractReadability extracts the main article of page with go-readability.
+func extractReadability(page string, pageURL *url.URL) (readability.Article, error) {
+	if !readability.Check(strings.NewReader(page)) {
+		return readability.Article{}, fmt.Errorf("the page is not readable")
+	}
+	return readability.FromReader(strings.NewReader(page), pageURL)
+}
+
+// extractRaw extracts the whole body of page, removing the elements that
+// don't render as content and resolving relative links against pageURL, so
+// that the archive can be viewed on its own.
+func extractRaw(page string, pageURL *url.URL) (readability.Article, error) {
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		return readability.Article{}, err
+	}
+	var clean func(n *html.Node)
+	clean = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			if c.Type == html.CommentNode || c.Type == html.ElementNode && isNonContentElement(c.Data) {
+				n.RemoveChild(c)
+			} else {
+				if c.Type == html.ElementNode {
+					resolveLinks(c, pageURL)
+				}
+				clean(c)
+			}
+			c = next
+		}
+	}
+	clean(doc)
+	return bodyArticle(doc)
+}
+
+// extractNone extracts the body of page without changing it.
+func extractNone(page string, pageURL *url.URL) (readability.Article, error) {
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		return readability.Article{}, err
+	}
+	return bodyArticle(doc)
+}
+
+// isNonContentElement reports whether elements named tag are left out of raw
+// extractions.
+func isNonContentElement(tag string) bool {
+	switch tag {
+	case "script", "style", "noscript", "template", "link":
+		return true
+	}
+	return false
+}
+
+// resolveLinks resolves the href and src attributes of n against pageURL.
+func resolveLinks(n *html.Node, pageURL *url.URL) {
+	if pageURL == nil {
+		return
+	}
+	for i, attr := range n.Attr {
+		if attr.Key != "href" && attr.Key != "src" {
+			continue
+		}
+		ref, err := url.Parse(strings.TrimSpace(attr.Val))
+		if err != nil || ref.IsAbs() || strings.HasPrefix(attr.Val, "#") {
+			continue
+		}
+		n.Attr[i].Val = pageURL.ResolveReference(ref).String()
+	}
+}
+
+// bodyArticle returns an article with the children of the body of doc as its
+// content, and the page's <title> as its title.
+func bodyArticle(doc *html.Node) (readability.Article, error) {
+	var article readability.Article
+	if title := findElement(doc, "title"); title != nil {
+		article.Title = strings.TrimSpace(textContent(title))
+	}
+	// html.Parse always adds a body, even to pages without one
+	body := findElement(doc, "body")
+	if body == nil {
+		return readability.Article{}, fmt.Errorf("cannot find body of page")
+	}
+	var buf bytes.Buffer
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&buf, c); err != nil {
+			return readability.Article{}, err
+		}
+	}
+	article.Content = strings.TrimSpace(buf.String())
+	article.TextContent = strings.TrimSpace(textContent(body))
+	article.Length = len(article.TextContent)
+	return article, nil
+}
+
+// textContent returns the text within n.
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(n)
+	return b.String()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testExtractHTML is a page with an article among navigation, scripts and
+// styles.
+var testExtractHTML = `<html><head><title>Extract Test</title><style>p { color: red }</style></head><body>` +
+	`<nav><a href="/home">Home</a></nav>` +
+	`<script>alert(1)</script><!-- tracking -->` +
+	`<article><h1>Extract Test</h1>` +
+	strings.Repeat(`<p>This is a paragraph of the article, with enough text that readability treats it as content worth keeping. `+
+		`<a href="/related">Related</a> reading is linked from it.</p>`, 10) +
+	`<img src="images/photo.jpg"></article>` +
+	`<footer>Footer</footer></body></html>`
+
+func TestExtract(t *testing.T) {
+	pageURL, err := url.Parse("https://example.com/posts/extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		extractor   string
+		contains    []string
+		notContains []string
+	}{
+		{
+			extractorReadability,
+			[]string{"paragraph of the article", `href="https://example.com/related"`},
+			[]string{"<nav>", "<script>", "Footer"},
+		},
+		{
+			extractorRaw,
+			[]string{"<nav>", "paragraph of the article", `href="https://example.com/home"`, `src="https://example.com/posts/images/photo.jpg"`, "Footer"},
+			[]string{"<script>", "<style>", "tracking"},
+		},
+		{
+			extractorNone,
+			[]string{"<nav>", "paragraph of the article", `href="/home"`, `src="images/photo.jpg"`, "<script>alert(1)</script>", "<!-- tracking -->", "Footer"},
+			[]string{"<style>"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.extractor, func(t *testing.T) {
+			extractor, err := newExtractor(tt.extractor)
+			if err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			a := &Archiver{Extractor: extractor}
+			article, err := a.extract(testExtractHTML, pageURL)
+			if err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			if article.Title != "Extract Test" {
+				t.Errorf("expected title %q, got %q", "Extract Test", article.Title)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(article.Content, s) {
+					t.Errorf("expected content to contain %q, got %q", s, article.Content)
+				}
+			}
+			for _, s := range tt.notContains {
+				if strings.Contains(article.Content, s) {
+					t.Errorf("expected content to not contain %q, got %q", s, article.Content)
+				}
+			}
+		})
+	}
+}
+
+func TestNewExtractorUnsupported(t *testing.T) {
+	if _, err := newExtractor("mercury"); !errors.Is(err, ErrUnsupportedExtractor) {
+		t.Errorf("expected %+v, got %+v", ErrUnsupportedExtractor, err)
+	}
+}
+
+func TestArchiveRawExtractor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(testExtractHTML))
+	}))
+	defer server.Close()
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	link := server.URL + "/posts/extract"
+	if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [extract]("+link+")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	extractor, err := newExtractor(extractorRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{
+		InputDir:        inputDir,
+		OutputDir:       outputDir,
+		Extractor:       extractor,
+		AllowLocal:      true,
+		AllowPrivateIPs: true,
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	linkID, err := getLinkID(link, defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(archivePath(outputDir, linkID, formatHTML))
+	if err != nil {
+		t.Fatalf("expected archive, got %+v", err)
+	}
+	if !strings.Contains(string(b), `<a href="`+server.URL+`/home">Home</a>`) {
+		t.Errorf("expected the whole body to be archived, got %q", string(b))
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// maxFaviconBytes is the size above which favicons are not saved.
+const maxFaviconBytes = 1 << 20
+
+// saveFavicon downloads the favicon of the page at link and writes it to
+// <linkDir>/favicon.ico. faviconURL is the icon declared by the page, if any;
+// otherwise /favicon.ico on the page's host is used.
+func (a *Archiver) saveFavicon(link, faviconURL, linkDir string) error {
+	if faviconURL == "" {
+		u, err := url.Parse(link)
+		if err != nil {
+			return err
+		}
+		faviconURL = (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/favicon.ico"}).String()
+	}
+
+	resp, err := a.httpClient().Get(faviconURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxFaviconBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(b) == 0 {
+		return fmt.Errorf("empty favicon")
+	}
+	if len(b) > maxFaviconBytes {
+		return fmt.Errorf("favicon is larger than %d bytes", maxFaviconBytes)
+	}
+	return writeArchive(path.Dir(linkDir), path.Join(linkDir, "favicon.ico"), bytes.NewReader(b))
+}
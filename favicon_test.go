@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveFavicon(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/favicon.ico":
+			w.Write([]byte("ico"))
+		case "/icon.png":
+			w.Write([]byte("png"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var tests = []struct {
+		name       string
+		faviconURL string
+		expected   string
+	}{
+		{"default favicon", "", "ico"},
+		{"declared favicon", server.URL + "/icon.png", "png"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			linkDir := filepath.Join(t.TempDir(), "example.com_abc")
+			if err := os.Mkdir(linkDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			a := &Archiver{AllowPrivateIPs: true}
+			err := a.saveFavicon(server.URL+"/article", tt.faviconURL, linkDir)
+			if err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			b, err := os.ReadFile(filepath.Join(linkDir, "favicon.ico"))
+			if err != nil {
+				t.Fatalf("expected favicon, got %+v", err)
+			}
+			if string(b) != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, string(b))
+			}
+		})
+	}
+}
+
+func TestSaveFaviconNotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	linkDir := filepath.Join(t.TempDir(), "example.com_abc")
+	if err := os.Mkdir(linkDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{AllowPrivateIPs: true}
+	err := a.saveFavicon(server.URL+"/article", "", linkDir)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if _, err := os.Stat(filepath.Join(linkDir, "favicon.ico")); !os.IsNotExist(err) {
+		t.Errorf("expected no favicon, got %+v", err)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/go-shiori/go-readability"
+	"golang.org/x/net/html/charset"
+)
+
+// fetchTimeout is the timeout for fetching a single link.
+const fetchTimeout = 5 * time.Second
+
+// httpClient returns the client used for outbound requests, which is
+// HTTPClient if it is set.
+func (a *Archiver) httpClient() *http.Client {
+	if a.HTTPClient != nil {
+		return a.HTTPClient
+	}
+	a.clientOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// bound the connection pool so that a run over many hosts doesn't
+		// exhaust file descriptors, while still reusing connections
+		transport.MaxIdleConns = maxIdleConns
+		transport.MaxIdleConnsPerHost = a.maxConnsPerHost()
+		transport.MaxConnsPerHost = a.maxConnsPerHost()
+		if a.Proxy != nil {
+			transport.Proxy = http.ProxyURL(a.Proxy)
+		} else {
+			transport.Proxy = http.ProxyFromEnvironment
+		}
+		var roundTripper http.RoundTripper = transport
+		if !a.AllowPrivateIPs {
+			guard := &privateAddressGuard{
+				archiver: a,
+				// the dialer of http.DefaultTransport
+				dialer: &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+				next:   transport,
+			}
+			transport.DialContext = guard.DialContext
+			roundTripper = guard
+		}
+		a.client = &http.Client{
+			Timeout:   fetchTimeout,
+			Transport: roundTripper,
+		}
+	})
+	return a.client
+}
+
+// parseProxyURL parses a proxy URL. http, https, and socks5 proxies are
+// supported. An empty string returns a nil URL.
+func parseProxyURL(proxy string) (*url.URL, error) {
+	if proxy == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("invalid proxy URL: missing host")
+	}
+	return u, nil
+}
+
+// Fetcher fetches a link and applies readability to it.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) (readability.Article, error)
+}
+
+// FetcherFunc adapts a function to a Fetcher.
+type FetcherFunc func(ctx context.Context, url string) (readability.Article, error)
+
+// Fetch calls f(ctx, url).
+func (f FetcherFunc) Fetch(ctx context.Context, url string) (readability.Article, error) {
+	return f(ctx, url)
+}
+
+// fetchedPage is an article along with details of the response it was
+// fetched from. The response details are only known when the page is fetched
+// by fetchFromURL.
+type fetchedPage struct {
+	readability.Article
+	StatusCode int
+	// FinalURL is the URL the page was fetched from, after redirects.
+	FinalURL string
+	// CanonicalURL is the URL the page declares as its canonical URL.
+	CanonicalURL string
+	// ContentLength is the length in bytes of the decoded page.
+	ContentLength int64
+	// Validators are the ETag and Last-Modified headers of the response.
+	Validators cacheValidators
+	// NotModified is set when the page hasn't changed since the version
+	// identified by the validators the fetch was conditional on, in which
+	// case the page has no content.
+	NotModified bool
+	// SkippedContentType is the Content-Type of the response when it
+	// matched SkipContentTypes, in which case the page has no content.
+	SkippedContentType string
+}
+
+// skipsContent reports whether the fetch deliberately left out the content of
+// the page, which shouldn't be captured another way.
+func (p fetchedPage) skipsContent() bool {
+	return p.NotModified || p.SkippedContentType != ""
+}
+
+// cacheValidators identify a version of a page, so that fetching it again can
+// be conditional on it having changed.
+type cacheValidators struct {
+	ETag         string
+	LastModified string
+}
+
+// fetch fetches link with Fetcher, or with fetchFromURL if it isn't set.
+// Fetcher doesn't support conditional fetches, so validators are ignored by
+// it.
+func (a *Archiver) fetch(ctx context.Context, link string, validators cacheValidators) (fetchedPage, error) {
+	if a.Fetcher != nil {
+		article, err := a.Fetcher.Fetch(ctx, link)
+		return fetchedPage{Article: article}, err
+	}
+	return a.fetchFromURL(ctx, link, validators)
+}
+
+// fetchFromURL fetches link, following the headers and rule configured for
+// its domain, and applies Extractor to the response. The request is made
+// conditional on any validators given, and a page that hasn't changed since
+// is returned as NotModified.
+func (a *Archiver) fetchFromURL(ctx context.Context, link string, validators cacheValidators) (fetchedPage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return fetchedPage{}, fmt.Errorf("failed to parse URL: %v", err)
+	}
+	// setting Accept-Encoding disables the transport's transparent gzip
+	// decoding, so responses are decoded by decodeBody instead
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	for k, v := range a.Headers.forHost(req.URL.Hostname()) {
+		req.Header[k] = v
+	}
+	rule := a.domainRule(link)
+	for k, v := range rule.header() {
+		req.Header[k] = v
+	}
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+	if a.Verbose {
+		a.logf(logEvent{Event: eventRequest, URL: link}, "GET %s (headers: %s)", link, headerNames(req.Header))
+	}
+
+	client := a.httpClient()
+	if rule.Timeout != 0 {
+		// a shallow copy shares the transport and its connections
+		withTimeout := *client
+		withTimeout.Timeout = time.Duration(rule.Timeout)
+		client = &withTimeout
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fetchedPage{}, fmt.Errorf("failed to fetch the page: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return fetchedPage{
+			StatusCode:  resp.StatusCode,
+			FinalURL:    resp.Request.URL.String(),
+			Validators:  validators,
+			NotModified: true,
+		}, nil
+	}
+
+	// skipped content types are checked before the HTML check, so that
+	// they aren't mistaken for failures
+	if contentType := resp.Header.Get("Content-Type"); matchesContentType(contentType, a.SkipContentTypes) {
+		return fetchedPage{
+			StatusCode:         resp.StatusCode,
+			FinalURL:           resp.Request.URL.String(),
+			SkippedContentType: contentType,
+		}, nil
+	}
+
+	// make sure content type is HTML
+	if !strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
+		return fetchedPage{}, fmt.Errorf("URL is not a HTML document")
+	}
+
+	body, err := decodeBody(resp)
+	if err != nil {
+		return fetchedPage{}, fmt.Errorf("failed to decode the page: %v", err)
+	}
+	defer body.Close()
+
+	// transcode the page to UTF-8, detecting its charset from the
+	// Content-Type header or <meta> tags
+	r, err := charset.NewReader(body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return fetchedPage{}, fmt.Errorf("failed to decode the page charset: %v", err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return fetchedPage{}, fmt.Errorf("failed to read the page: %v", err)
+	}
+
+	article, err := a.extract(string(b), req.URL)
+	if err != nil {
+		return fetchedPage{}, err
+	}
+	return fetchedPage{
+		Article:       article,
+		StatusCode:    resp.StatusCode,
+		FinalURL:      resp.Request.URL.String(),
+		CanonicalURL:  extractCanonicalURL(string(b), resp.Request.URL),
+		ContentLength: int64(len(b)),
+		Validators: cacheValidators{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		},
+	}, nil
+}
+
+// acceptEncoding lists the content encodings that decodeBody can decode.
+const acceptEncoding = "gzip, deflate, br"
+
+// decodeBody returns the body of resp decoded according to its
+// Content-Encoding. Encodings are applied in the order they are listed, so
+// they are decoded in reverse.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	body := resp.Body
+	var encodings []string
+	for _, v := range resp.Header.Values("Content-Encoding") {
+		for _, encoding := range strings.Split(v, ",") {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding != "" && encoding != "identity" {
+				encodings = append(encodings, encoding)
+			}
+		}
+	}
+	for i := len(encodings) - 1; i >= 0; i-- {
+		switch encodings[i] {
+		case "gzip", "x-gzip":
+			r, err := gzip.NewReader(body)
+			if err != nil {
+				return nil, err
+			}
+			body = r
+		case "deflate":
+			r, err := newDeflateReader(body)
+			if err != nil {
+				return nil, err
+			}
+			body = r
+		case "br":
+			body = io.NopCloser(brotli.NewReader(body))
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %q", encodings[i])
+		}
+	}
+	return body, nil
+}
+
+// newDeflateReader returns a reader for a deflate encoded body. The deflate
+// encoding is zlib wrapped DEFLATE, but some servers send raw DEFLATE, so fall
+// back to that when there is no zlib header.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if (uint16(header[0])<<8|uint16(header[1]))%31 == 0 && header[0]&0x0f == 8 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+// testArticleHTML is a page that readability considers readable.
+var testArticleHTML = `<html><head><title>Test Article</title></head><body><article>` +
+	strings.Repeat(`<p>This is a paragraph of the test article. It needs to be long enough for readability to consider the page readable, which means well over a hundred and forty characters.</p>`, 10) +
+	`</article></body></html>`
+
+func TestFetchFromURLSendsHeaders(t *testing.T) {
+	var cookie string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie = r.Header.Get("Cookie")
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(testArticleHTML))
+	}))
+	defer server.Close()
+
+	a := &Archiver{
+		Headers: HostHeaders{"127.0.0.1": {"Cookie": "session=abc"}},
+		// the test server listens on a loopback address
+		AllowPrivateIPs: true,
+	}
+	article, err := a.fetchFromURL(context.Background(), server.URL, cacheValidators{})
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if cookie != "session=abc" {
+		t.Errorf("expected cookie %q, got %q", "session=abc", cookie)
+	}
+	if article.Title != "Test Article" {
+		t.Errorf("expected title %q, got %q", "Test Article", article.Title)
+	}
+}
+
+func TestFetchFromURLNotHTML(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	a := &Archiver{AllowPrivateIPs: true}
+	_, err := a.fetchFromURL(context.Background(), server.URL, cacheValidators{})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestFetchFromURLThroughProxy(t *testing.T) {
+	var proxiedURL string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedURL = r.URL.String()
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(testArticleHTML))
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{Proxy: proxyURL, AllowPrivateIPs: true}
+	_, err = a.fetchFromURL(context.Background(), "http://example.invalid/abc", cacheValidators{})
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if proxiedURL != "http://example.invalid/abc" {
+		t.Errorf("expected request to go through proxy, got %q", proxiedURL)
+	}
+}
+
+func TestParseProxyURL(t *testing.T) {
+	var tests = []struct {
+		name      string
+		given     string
+		expectErr bool
+	}{
+		{"empty", "", false},
+		{"http", "http://proxy.example.com:8080", false},
+		{"https", "https://proxy.example.com", false},
+		{"socks5", "socks5://127.0.0.1:1080", false},
+		{"unsupported scheme", "ftp://proxy.example.com", true},
+		{"missing host", "http://", true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseProxyURL(tt.given)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("(%+v): expected error %v, got %+v", tt.given, tt.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestFetchFromURLCompressed(t *testing.T) {
+	var tests = []struct {
+		name     string
+		encoding string
+		encode   func(w io.Writer) io.WriteCloser
+	}{
+		{"gzip", "gzip", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+		{"deflate", "deflate", func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }},
+		{"raw deflate", "deflate", func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		}},
+		{"brotli", "br", func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var acceptEncoding string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				acceptEncoding = r.Header.Get("Accept-Encoding")
+				w.Header().Set("Content-Type", "text/html")
+				w.Header().Set("Content-Encoding", tt.encoding)
+				enc := tt.encode(w)
+				enc.Write([]byte(testArticleHTML))
+				enc.Close()
+			}))
+			defer server.Close()
+
+			a := &Archiver{AllowPrivateIPs: true}
+			article, err := a.fetchFromURL(context.Background(), server.URL, cacheValidators{})
+			if err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			if !strings.Contains(acceptEncoding, tt.encoding) {
+				t.Errorf("expected Accept-Encoding to include %q, got %q", tt.encoding, acceptEncoding)
+			}
+			if article.Title != "Test Article" {
+				t.Errorf("expected title %q, got %q", "Test Article", article.Title)
+			}
+		})
+	}
+}
+
+func TestFetchFromURLUnsupportedEncoding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", "zstd")
+		w.Write([]byte(testArticleHTML))
+	}))
+	defer server.Close()
+
+	a := &Archiver{AllowPrivateIPs: true}
+	_, err := a.fetchFromURL(context.Background(), server.URL, cacheValidators{})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestFetchFromURLCharset(t *testing.T) {
+	// "Café crème" and the paragraphs of testArticleHTML, encoded as Latin-1
+	latin1Title := "Caf\xe9 cr\xe8me"
+	page := strings.Replace(testArticleHTML, "<p>", "<p>"+latin1Title+". ", -1)
+	page = strings.Replace(page, "<title>Test Article</title>", "<title>"+latin1Title+"</title>", 1)
+	var tests = []struct {
+		name        string
+		contentType string
+		page        string
+	}{
+		{"content type header", "text/html; charset=ISO-8859-1", page},
+		{"meta charset", "text/html", strings.Replace(page, "<head>", `<head><meta charset="ISO-8859-1">`, 1)},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(tt.page))
+			}))
+			defer server.Close()
+
+			a := &Archiver{AllowPrivateIPs: true}
+			article, err := a.fetchFromURL(context.Background(), server.URL, cacheValidators{})
+			if err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			if article.Title != "Café crème" {
+				t.Errorf("expected title %q, got %q", "Café crème", article.Title)
+			}
+			if !strings.Contains(article.Content, "Café crème") {
+				t.Errorf("expected UTF-8 content, got %q", article.Content)
+			}
+		})
+	}
+}
+
+func TestFetchFromURLResponseDetails(t *testing.T) {
+	var tests = []struct {
+		name   string
+		status int
+	}{
+		{"ok", http.StatusOK},
+		{"error page", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(testArticleHTML))
+			}))
+			defer server.Close()
+
+			a := &Archiver{AllowPrivateIPs: true}
+			page, err := a.fetchFromURL(context.Background(), server.URL, cacheValidators{})
+			if err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			if page.StatusCode != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, page.StatusCode)
+			}
+			if page.ContentLength != int64(len(testArticleHTML)) {
+				t.Errorf("expected content length %d, got %d", len(testArticleHTML), page.ContentLength)
+			}
+		})
+	}
+}
+
+// roundTripFunc is an http.RoundTripper backed by a function.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestFetchFromURLHTTPClient(t *testing.T) {
+	var requested []string
+	a := &Archiver{
+		HTTPClient: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				requested = append(requested, req.URL.String())
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": []string{"text/html"}},
+					Body:       io.NopCloser(strings.NewReader(testArticleHTML)),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+	page, err := a.fetchFromURL(context.Background(), "https://example.com/abc", cacheValidators{})
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if page.Title != "Test Article" {
+		t.Errorf("expected title %q, got %q", "Test Article", page.Title)
+	}
+	if len(requested) != 1 || requested[0] != "https://example.com/abc" {
+		t.Errorf("expected request through HTTPClient, got %+v", requested)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats for archived content.
+const (
+	formatHTML       = "html"
+	formatMarkdown   = "md"
+	formatSingleFile = "singlefile"
+)
+
+// maxInlineResourceBytes is the size above which images and stylesheets are
+// not inlined into single-file archives.
+const maxInlineResourceBytes = 10 << 20
+
+// archivePath returns the path of the archived file for linkID in the given
+// format. Single-file archives are written directly to the output directory,
+// other formats are written to a directory per link.
+func archivePath(outputDir, linkID, format string) string {
+	switch format {
+	case formatMarkdown:
+		return path.Join(outputDir, linkID, "index.md")
+	case formatSingleFile:
+		return path.Join(outputDir, linkID+".html")
+	default:
+		return path.Join(outputDir, linkID, "index.html")
+	}
+}
+
+// Default templates of archived files. Single-file archives embed the
+// frontmatter in an HTML comment so that the file remains valid HTML.
+var (
+	defaultTemplate    = template.Must(newTemplate("default").Parse("---\n{{.Frontmatter}}\n---\n{{.Content}}"))
+	singleFileTemplate = template.Must(newTemplate("singlefile").Parse("<!--\n---\n{{.Frontmatter}}\n---\n-->\n{{.Content}}"))
+)
+
+// templateData is the data an archive template is executed with.
+type templateData struct {
+	Metadata Metadata
+	// Frontmatter is Metadata encoded as YAML.
+	Frontmatter string
+	// Content is the rendered content of the archive.
+	Content string
+}
+
+// newTemplate returns an archive template with the functions available to
+// archive templates:
+//
+//   - json encodes a value as JSON, which is also valid as a TOML string
+//   - yaml encodes a value as YAML
+func newTemplate(name string) *template.Template {
+	return template.New(name).Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"yaml": func(v interface{}) (string, error) {
+			b, err := yaml.Marshal(v)
+			return strings.TrimSuffix(string(b), "\n"), err
+		},
+	})
+}
+
+// loadTemplate parses the archive template at filePath.
+func loadTemplate(filePath string) (*template.Template, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return newTemplate(path.Base(filePath)).Parse(string(b))
+}
+
+// archiveContent returns the contents of the archived file of metadata, with
+// metadata as its YAML frontmatter and the rendered body.
+func (a *Archiver) archiveContent(metadata Metadata, body string) (string, error) {
+	b, err := yaml.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal yaml frontmatter: %v", err)
+	}
+	content, err := a.formatArchive(metadata, strings.Trim(string(b), "\n"), body)
+	if err != nil {
+		return "", fmt.Errorf("cannot apply template: %v", err)
+	}
+	return content, nil
+}
+
+// formatArchive combines the metadata, its YAML frontmatter, and the rendered
+// body into the contents of an archived file using the archive template.
+func (a *Archiver) formatArchive(metadata Metadata, frontmatter, body string) (string, error) {
+	tmpl := a.Template
+	if tmpl == nil && a.Format == formatSingleFile {
+		tmpl = singleFileTemplate
+	} else if tmpl == nil {
+		tmpl = defaultTemplate
+	}
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, templateData{
+		Metadata:    metadata,
+		Frontmatter: frontmatter,
+		Content:     body,
+	})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderContent converts the HTML content of an article into the archive's
+// output format.
+func (a *Archiver) renderContent(content string) (string, error) {
+	switch a.Format {
+	case formatMarkdown:
+		converter := md.NewConverter("", true, &md.Options{
+			HeadingStyle:   "atx",
+			CodeBlockStyle: "fenced",
+		})
+		return converter.ConvertString(content)
+	case formatSingleFile:
+		return a.inlineResources(content)
+	default:
+		return content, nil
+	}
+}
+
+// inlineResources downloads the images and stylesheets referenced by content
+// and inlines them, so that the content is self-contained. Images become data
+// URIs, and linked stylesheets become <style> elements. The url() references of
+// stylesheets, <style> elements and style attributes, such as background
+// images and fonts, become data URIs too. Only absolute references can be
+// downloaded, and resources that cannot be downloaded keep their original
+// reference. Extractors other than none leave the stylesheets of a page out of
+// its content, so they aren't there to be inlined.
+func (a *Archiver) inlineResources(content string) (string, error) {
+	// parse the content as the children of a body, so that stylesheets at
+	// its start aren't moved into a head
+	nodes, err := html.ParseFragment(strings.NewReader(content), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return "", err
+	}
+
+	// cache downloaded resources in case one is referenced more than once
+	dataURIs := make(map[string]string)
+	dataURI := func(link string) string {
+		if uri, ok := dataURIs[link]; ok {
+			return uri
+		}
+		uri, err := a.fetchDataURI(link)
+		if err != nil {
+			a.logf(logEvent{Event: eventWarning, URL: link, Err: err}, "cannot inline %+v: %+v", link, err)
+		}
+		dataURIs[link] = uri
+		return uri
+	}
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.Data == "img":
+				attrs := n.Attr[:0]
+				for _, attr := range n.Attr {
+					switch attr.Key {
+					case "srcset":
+						// drop srcset so that browsers use the inlined src
+						continue
+					case "src":
+						if isAbsoluteHTTPURL(attr.Val) {
+							if uri := dataURI(attr.Val); uri != "" {
+								attr.Val = uri
+							}
+						}
+					}
+					attrs = append(attrs, attr)
+				}
+				n.Attr = attrs
+			case n.Data == "link" && isStylesheetLink(n):
+				a.inlineStylesheet(n, dataURI)
+			case n.Data == "style":
+				for c := n.FirstChild; c != nil; c = c.NextSibling {
+					if c.Type == html.TextNode {
+						c.Data = inlineCSSURLs(c.Data, nil, dataURI)
+					}
+				}
+			}
+			for i, attr := range n.Attr {
+				if attr.Key == "style" {
+					n.Attr[i].Val = inlineCSSURLs(attr.Val, nil, dataURI)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		visit(n)
+		if err := html.Render(&buf, n); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// inlineStylesheet replaces n, a <link> to a stylesheet, with a <style>
+// element holding the stylesheet, whose url() references are resolved against
+// the stylesheet's URL and inlined with dataURI. A stylesheet that cannot be
+// downloaded is left linked.
+func (a *Archiver) inlineStylesheet(n *html.Node, dataURI func(link string) string) {
+	var href, media string
+	for _, attr := range n.Attr {
+		switch attr.Key {
+		case "href":
+			href = attr.Val
+		case "media":
+			media = attr.Val
+		}
+	}
+	if !isAbsoluteHTTPURL(href) {
+		return
+	}
+	b, _, err := a.fetchResource(href)
+	if err != nil {
+		a.logf(logEvent{Event: eventWarning, URL: href, Err: err}, "cannot inline stylesheet %+v: %+v", href, err)
+		return
+	}
+	base, err := url.Parse(href)
+	if err != nil {
+		return
+	}
+	css := inlineCSSURLs(string(b), base, dataURI)
+	// the contents of <style> aren't escaped when rendered, so keep the
+	// stylesheet from closing the element
+	css = strings.ReplaceAll(css, "</style", `<\/style`)
+	n.Data, n.DataAtom = "style", atom.Style
+	n.Attr = nil
+	if media != "" {
+		n.Attr = []html.Attribute{{Key: "media", Val: media}}
+	}
+	n.AppendChild(&html.Node{Type: html.TextNode, Data: css})
+}
+
+// cssURLRegex matches the url() references of a stylesheet, with the URL
+// either quoted or bare.
+var cssURLRegex = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"\s]*))\s*\)`)
+
+// inlineCSSURLs replaces the url() references of css with data URIs from
+// dataURI. References are resolved against base, or left alone when they are
+// relative and base is nil.
+func inlineCSSURLs(css string, base *url.URL, dataURI func(link string) string) string {
+	return cssURLRegex.ReplaceAllStringFunc(css, func(match string) string {
+		groups := cssURLRegex.FindStringSubmatch(match)
+		ref := strings.TrimSpace(groups[1] + groups[2] + groups[3])
+		if ref == "" || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "#") {
+			return match
+		}
+		if base != nil {
+			resolved, err := base.Parse(ref)
+			if err != nil {
+				return match
+			}
+			ref = resolved.String()
+		}
+		if !isAbsoluteHTTPURL(ref) {
+			return match
+		}
+		uri := dataURI(ref)
+		if uri == "" {
+			return match
+		}
+		return `url("` + uri + `")`
+	})
+}
+
+// isStylesheetLink reports whether n is a <link> to a stylesheet.
+func isStylesheetLink(n *html.Node) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == "rel" {
+			for _, rel := range strings.Fields(attr.Val) {
+				if strings.EqualFold(rel, "stylesheet") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isAbsoluteHTTPURL reports whether link is an absolute http or https URL.
+func isAbsoluteHTTPURL(link string) bool {
+	return strings.HasPrefix(link, "http://") || strings.HasPrefix(link, "https://")
+}
+
+// fetchDataURI downloads link and returns it encoded as a data URI.
+func (a *Archiver) fetchDataURI(link string) (string, error) {
+	b, contentType, err := a.fetchResource(link)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// fetchResource downloads link, an image or stylesheet of an archived page,
+// and returns it with its content type.
+func (a *Archiver) fetchResource(link string) ([]byte, string, error) {
+	resp, err := a.httpClient().Get(link)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxInlineResourceBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(b) > maxInlineResourceBytes {
+		return nil, "", fmt.Errorf("resource is larger than %d bytes", maxInlineResourceBytes)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(b)
+	}
+	return b, contentType, nil
+}
+
+// findElement returns the first element named tag in the tree rooted at n.
+func findElement(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
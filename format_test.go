@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestRenderContentMarkdown(t *testing.T) {
+	content := `<h2>Heading</h2><p>See <a href="https://example.com">example</a>.</p><pre><code>fmt.Println("hi")</code></pre>`
+	result, err := (&Archiver{Format: formatMarkdown}).renderContent(content)
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	for _, expected := range []string{
+		"## Heading",
+		"[example](https://example.com)",
+		"```\nfmt.Println(\"hi\")\n```",
+	} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("expected %q in %q", expected, result)
+		}
+	}
+}
+
+func TestRenderContentHTML(t *testing.T) {
+	content := "<p>abc</p>"
+	result, err := (&Archiver{Format: formatHTML}).renderContent(content)
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if result != content {
+		t.Errorf("expected %q, got %q", content, result)
+	}
+}
+
+func TestArchiveMarkdownFormat(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc](https://example.com)"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{
+		InputDir:  inputDir,
+		OutputDir: outputDir,
+		Format:    formatMarkdown,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			return readability.Article{Title: "Example", Content: "<h1>Example</h1>"}, nil
+		}),
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	linkID, err := getLinkID("https://example.com", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archivedFilePath := filepath.Join(outputDir, linkID, "index.md")
+	metadata, err := readMetadata(archivedFilePath)
+	if err != nil {
+		t.Fatalf("expected metadata, got %+v", err)
+	}
+	if metadata.URL != "https://example.com" || metadata.Title != "Example" {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+	b, err := os.ReadFile(archivedFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(b), "\n---\n# Example") {
+		t.Errorf("expected markdown content, got %q", string(b))
+	}
+}
+
+func TestRenderContentSingleFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/image.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer server.Close()
+
+	content := `<p>abc</p><img src="` + server.URL + `/image.png" srcset="` + server.URL + `/image-2x.png 2x"/><img src="` + server.URL + `/missing.png"/>`
+	result, err := (&Archiver{Format: formatSingleFile, AllowPrivateIPs: true}).renderContent(content)
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	expected := `<p>abc</p><img src="data:image/png;base64,cG5n"/><img src="` + server.URL + `/missing.png"/>`
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestRenderContentSingleFileStylesheets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/css/style.css":
+			w.Header().Set("Content-Type", "text/css")
+			w.Write([]byte(`body { background: url("../images/bg.png") } p::after { content: "</style>" }`))
+		case "/images/bg.png", "/images/icon.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	content := `<link rel="stylesheet" media="screen" href="` + server.URL + `/css/style.css"/>` +
+		`<link rel="stylesheet" href="` + server.URL + `/missing.css"/>` +
+		`<style>.icon { background: url('` + server.URL + `/images/icon.png') }</style>` +
+		`<p style="background: url(` + server.URL + `/images/icon.png)">abc</p>`
+	result, err := (&Archiver{Format: formatSingleFile, AllowPrivateIPs: true, stderr: &bytes.Buffer{}}).renderContent(content)
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	dataURI := `url("data:image/png;base64,cG5n")`
+	expected := `<style media="screen">body { background: ` + dataURI + ` } p::after { content: "<\/style>" }</style>` +
+		`<link rel="stylesheet" href="` + server.URL + `/missing.css"/>` +
+		`<style>.icon { background: ` + dataURI + ` }</style>` +
+		`<p style="background: ` + html.EscapeString(dataURI) + `">abc</p>`
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestArchiveSingleFileFormat(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc](https://example.com)"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{
+		InputDir:  inputDir,
+		OutputDir: outputDir,
+		Format:    formatSingleFile,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	linkID, err := getLinkID("https://example.com", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archivedFilePath := filepath.Join(outputDir, linkID+".html")
+	b, err := os.ReadFile(archivedFilePath)
+	if err != nil {
+		t.Fatalf("expected archived file, got %+v", err)
+	}
+	if !strings.HasPrefix(string(b), "<!--\n---\n") || !strings.HasSuffix(string(b), "\n---\n-->\n<p>abc</p>") {
+		t.Errorf("expected metadata in a comment block, got %q", string(b))
+	}
+	metadata, err := readMetadata(archivedFilePath)
+	if err != nil {
+		t.Fatalf("expected metadata, got %+v", err)
+	}
+	if metadata.URL != "https://example.com" {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, linkID)); !os.IsNotExist(err) {
+		t.Errorf("expected no link directory, got %+v", err)
+	}
+}
+
+func TestFormatArchive(t *testing.T) {
+	tomlTemplate, err := newTemplate("toml").Parse("+++\ntitle = {{json .Metadata.Title}}\nurl = {{json .Metadata.URL}}\n+++\n{{.Content}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name     string
+		format   string
+		template *template.Template
+		expected string
+	}{
+		{"default", formatHTML, nil, "---\nurl: https://example.com\n---\n<p>abc</p>"},
+		{"singlefile", formatSingleFile, nil, "<!--\n---\nurl: https://example.com\n---\n-->\n<p>abc</p>"},
+		{"custom", formatHTML, tomlTemplate, "+++\ntitle = \"Say \\\"hi\\\"\"\nurl = \"https://example.com\"\n+++\n<p>abc</p>"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Archiver{Format: tt.format, Template: tt.template}
+			metadata := Metadata{URL: "https://example.com", Title: `Say "hi"`}
+			result, err := a.formatArchive(metadata, "url: https://example.com", "<p>abc</p>")
+			if err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestLoadTemplate(t *testing.T) {
+	templatePath := filepath.Join(t.TempDir(), "archive.tmpl")
+	err := os.WriteFile(templatePath, []byte("{{yaml .Metadata.Tags}}\n{{.Content}}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := loadTemplate(templatePath)
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	a := &Archiver{Template: tmpl}
+	result, err := a.formatArchive(Metadata{Tags: []string{"go"}}, "", "<p>abc</p>")
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if result != "- go\n<p>abc</p>" {
+		t.Errorf("expected %q, got %q", "- go\n<p>abc</p>", result)
+	}
+}
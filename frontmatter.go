@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// maxFrontmatterBytes is the most of a file that is read for its frontmatter
+// when the rest of the file is streamed.
+const maxFrontmatterBytes = 1 << 20
+
+// frontmatterEndRegex matches the line closing a YAML frontmatter block.
+var frontmatterEndRegex = regexp.MustCompile(`(?m)^(?:---|\.\.\.)[ \t]*\r?$`)
+
+// splitFrontmatter returns the YAML frontmatter at the start of markdown,
+// without its delimiters, and whether markdown has any.
+func splitFrontmatter(markdown string) (string, bool) {
+	rest, ok := strings.CutPrefix(markdown, "---\n")
+	if !ok {
+		rest, ok = strings.CutPrefix(markdown, "---\r\n")
+	}
+	if !ok {
+		return "", false
+	}
+	end := frontmatterEndRegex.FindStringIndex(rest)
+	if end == nil {
+		return "", false
+	}
+	return rest[:end[0]], true
+}
+
+// parseLinksFromFrontmatter returns the http and https URLs in the given
+// top-level fields of the YAML frontmatter of markdown. A field may hold a
+// URL or a list of URLs; other values are ignored.
+func parseLinksFromFrontmatter(markdown string, fields []string) ([]markdownLink, error) {
+	frontmatter, ok := splitFrontmatter(markdown)
+	if !ok || len(fields) == 0 {
+		return nil, nil
+	}
+	var items yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(frontmatter), &items); err != nil {
+		return nil, err
+	}
+	lines := strings.Split(frontmatter, "\n")
+	var links []markdownLink
+	for _, item := range items {
+		key, ok := item.Key.(string)
+		if !ok || !slices.Contains(fields, key) {
+			continue
+		}
+		var values []interface{}
+		if list, ok := item.Value.([]interface{}); ok {
+			values = list
+		} else {
+			values = []interface{}{item.Value}
+		}
+		// the frontmatter starts on line 2, after its opening delimiter,
+		// and the URLs of a field follow its key
+		keyRegex := regexp.MustCompile(`^` + regexp.QuoteMeta(key) + `[ \t]*:`)
+		line := 0
+		for line < len(lines) && !keyRegex.MatchString(lines[line]) {
+			line++
+		}
+		for _, value := range values {
+			link, ok := value.(string)
+			if !ok || !isHTTPURL(link) {
+				continue
+			}
+			for l := line; l < len(lines); l++ {
+				if strings.Contains(lines[l], link) {
+					line = l
+					break
+				}
+			}
+			links = append(links, markdownLink{URL: link, Line: line + 2})
+		}
+	}
+	return links, nil
+}
+
+// frontmatterLinks returns the links in the FrontmatterFields of the
+// frontmatter of markdown, which was read from filePath. Invalid frontmatter
+// is logged rather than failing the file, since the body may still have
+// links.
+func (a *Archiver) frontmatterLinks(filePath, markdown string) []markdownLink {
+	links, err := parseLinksFromFrontmatter(markdown, a.FrontmatterFields)
+	if err != nil {
+		source := a.relativeInputPath(filePath)
+		a.logf(logEvent{Event: eventWarning, SourceFile: source, Err: err}, "cannot parse frontmatter of %s: %+v", source, err)
+	}
+	return links
+}
+
+// isHTTPURL reports whether link is an absolute http or https URL.
+func isHTTPURL(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLinksFromFrontmatter(t *testing.T) {
+	var tests = []struct {
+		name     string
+		markdown string
+		expected []markdownLink
+	}{
+		{
+			"scalar field",
+			"---\ntitle: Notes\nurl: https://example.com/a\n---\nbody\n",
+			[]markdownLink{{URL: "https://example.com/a", Line: 3}},
+		},
+		{
+			"flow list field",
+			"---\nsources: [https://example.com/a, https://example.com/b]\n---\n",
+			[]markdownLink{{URL: "https://example.com/a", Line: 2}, {URL: "https://example.com/b", Line: 2}},
+		},
+		{
+			"block list field",
+			"---\nsources:\n  - https://example.com/a\n  - not a url\n  - https://example.com/b\n...\n",
+			[]markdownLink{{URL: "https://example.com/a", Line: 3}, {URL: "https://example.com/b", Line: 5}},
+		},
+		{
+			"other fields",
+			"---\nhomepage: https://example.com/a\ntags: [https, go]\n---\n",
+			nil,
+		},
+		{
+			"non-http url",
+			"---\nurl: mailto:a@example.com\n---\n",
+			nil,
+		},
+		{
+			"no frontmatter",
+			"url: https://example.com/a\n",
+			nil,
+		},
+		{
+			"unterminated frontmatter",
+			"---\nurl: https://example.com/a\n",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseLinksFromFrontmatter(tt.markdown, []string{"url", "sources", "tags"})
+			if err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestListLinksFrontmatterFields(t *testing.T) {
+	inputDir := t.TempDir()
+	files := map[string]string{
+		"notes.md":   "---\nsources: [https://example.com/a, https://example.com/b]\n---\n [c](https://example.com/c)\n",
+		"invalid.md": "---\nsources: [https://example.com/d\n---\n [e](https://example.com/e)\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var stderr bytes.Buffer
+	a := &Archiver{InputDir: inputDir, FrontmatterFields: []string{"sources"}, stderr: &stderr}
+	links, err := a.ListLinks()
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	expected := []string{"https://example.com/a", "https://example.com/b", "https://example.com/c", "https://example.com/e"}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("expected %+v, got %+v", expected, links)
+	}
+	if !strings.Contains(stderr.String(), "cannot parse frontmatter of invalid.md") {
+		t.Errorf("expected a warning for invalid frontmatter, got %q", stderr.String())
+	}
+}
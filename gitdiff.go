@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// initGitDiff finds the lines added to the input directory since GitDiffBase.
+// When the input directory isn't in a git repository, or git isn't
+// installed, every input file is processed instead.
+func (a *Archiver) initGitDiff(ctx context.Context) error {
+	a.gitAddedLines = nil
+	if a.GitDiffBase == "" || a.InputDir == stdinInput {
+		return nil
+	}
+	err := exec.CommandContext(ctx, "git", "-C", a.InputDir, "rev-parse", "--is-inside-work-tree").Run()
+	if err != nil {
+		a.logf(logEvent{Event: eventWarning, Err: err}, "cannot find a git repository for %s, archiving links in all files: %+v", a.InputDir, err)
+		return nil
+	}
+	// diff the working tree rather than HEAD, so that uncommitted changes
+	// are included, and with no context so that every + line is added
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "-C", a.InputDir, "-c", "core.quotePath=false",
+		"diff", "--no-color", "--no-ext-diff", "--unified=0", "--relative", a.GitDiffBase, "--", ".")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cannot diff against %s: %w: %s", a.GitDiffBase, err, strings.TrimSpace(stderr.String()))
+	}
+	added, err := parseGitDiff(&stdout)
+	if err != nil {
+		return err
+	}
+	a.gitAddedLines = make(map[string]map[int]bool, len(added))
+	for name, lines := range added {
+		a.gitAddedLines[filepath.Join(a.InputDir, name)] = lines
+	}
+	return nil
+}
+
+// parseGitDiff returns the lines added to each file in a unified diff
+// without context, keyed by the files' new paths. Deleted files are omitted.
+func parseGitDiff(r io.Reader) (map[string]map[int]bool, error) {
+	added := make(map[string]map[int]bool)
+	// lines are the added lines of the current file, and line is the
+	// number of the next line in the current hunk
+	var lines map[int]bool
+	line := 0
+	// inHeader is set between the start of a file's diff and its first
+	// hunk, where +++ names the file rather than adding a line
+	inHeader := false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		text := scanner.Text()
+		switch {
+		case strings.HasPrefix(text, "diff --git "):
+			inHeader = true
+			lines = nil
+		case inHeader && strings.HasPrefix(text, "+++ "):
+			name := strings.TrimPrefix(text, "+++ ")
+			if name != "/dev/null" {
+				lines = make(map[int]bool)
+				added[strings.TrimPrefix(name, "b/")] = lines
+			}
+		case strings.HasPrefix(text, "@@ "):
+			// @@ -<start>[,<count>] +<start>[,<count>] @@
+			inHeader = false
+			fields := strings.Fields(text)
+			if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+				return nil, fmt.Errorf("invalid hunk header %q", text)
+			}
+			start, _, _ := strings.Cut(strings.TrimPrefix(fields[2], "+"), ",")
+			n, err := strconv.Atoi(start)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hunk header %q", text)
+			}
+			line = n
+		case !inHeader && lines != nil && strings.HasPrefix(text, "+"):
+			lines[line] = true
+			line++
+		}
+	}
+	return added, scanner.Err()
+}
+
+// isFileOutsideGitDiff reports whether an input file can be skipped because
+// no lines were added to it since GitDiffBase.
+func (a *Archiver) isFileOutsideGitDiff(filePath string) bool {
+	return a.gitAddedLines != nil && a.gitAddedLines[filepath.Clean(filePath)] == nil
+}
+
+// filterGitDiff removes the links in filePath that aren't on lines added
+// since GitDiffBase.
+func (a *Archiver) filterGitDiff(filePath string, links []markdownLink) []markdownLink {
+	if a.gitAddedLines == nil {
+		return links
+	}
+	added := a.gitAddedLines[filepath.Clean(filePath)]
+	filtered := links[:0]
+	for _, link := range links {
+		if added[link.Line] {
+			filtered = append(filtered, link)
+		}
+	}
+	return filtered
+}
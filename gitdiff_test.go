@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestParseGitDiff(t *testing.T) {
+	diff := `diff --git a/notes.md b/notes.md
+index 1111111..2222222 100644
+--- a/notes.md
++++ b/notes.md
+@@ -2 +2,2 @@ heading
+-old line
++++ a line starting with plus signs
++second line
+@@ -10,0 +12 @@
++appended
+diff --git a/removed.md b/removed.md
+deleted file mode 100644
+--- a/removed.md
++++ /dev/null
+@@ -1 +0,0 @@
+-gone
+diff --git a/new.md b/new.md
+new file mode 100644
+--- /dev/null
++++ b/new.md
+@@ -0,0 +1 @@
++new
+`
+	result, err := parseGitDiff(strings.NewReader(diff))
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	expected := map[string]map[int]bool{
+		"notes.md": {2: true, 3: true, 12: true},
+		"new.md":   {1: true},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestArchiveGitDiff(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repoDir := t.TempDir()
+	inputDir := filepath.Join(repoDir, "notes")
+	if err := os.Mkdir(inputDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repoDir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %+v: %s", strings.Join(args, " "), err, out)
+		}
+	}
+	writeFile := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	writeFile("old.md", " [old](https://example.com/old)\n")
+	writeFile("unchanged.md", " [unchanged](https://example.com/unchanged)\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	writeFile("old.md", " [old](https://example.com/old)\n [added](https://example.com/added)\n")
+	writeFile("new.md", " [new](https://example.com/new)\n")
+	git("add", "notes/new.md")
+
+	var fetched []string
+	a := &Archiver{
+		InputDir:    inputDir,
+		OutputDir:   t.TempDir(),
+		GitDiffBase: "HEAD",
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			fetched = append(fetched, link)
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+		stdout: &bytes.Buffer{},
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	sort.Strings(fetched)
+	expected := []string{"https://example.com/added", "https://example.com/new"}
+	if !reflect.DeepEqual(fetched, expected) {
+		t.Errorf("expected %+v to be fetched, got %+v", expected, fetched)
+	}
+
+	var stderr bytes.Buffer
+	a.GitDiffBase = "missing-ref"
+	a.stderr = &stderr
+	if err := a.Archive(); err == nil {
+		t.Errorf("expected error diffing against a missing ref, got nil")
+	}
+}
+
+func TestArchiveGitDiffNotRepository(t *testing.T) {
+	inputDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc](https://example.com/abc)\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	fetched := 0
+	a := &Archiver{
+		InputDir:    inputDir,
+		OutputDir:   t.TempDir(),
+		GitDiffBase: "HEAD",
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			fetched++
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+		stdout: &bytes.Buffer{},
+		stderr: &stderr,
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if fetched != 1 {
+		t.Errorf("expected all links to be fetched outside a git repository, got %d fetches", fetched)
+	}
+	if !strings.Contains(stderr.String(), "archiving links in all files") {
+		t.Errorf("expected a warning, got %q", stderr.String())
+	}
+}
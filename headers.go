@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// HostHeaders maps a domain to additional HTTP headers sent with requests to
+// that domain and its subdomains, e.g.
+//
+//	example.com:
+//	  Cookie: session=abc
+//	  Authorization: Bearer xyz
+type HostHeaders map[string]map[string]string
+
+// loadHostHeaders reads HostHeaders from a YAML file. An empty path returns no
+// headers.
+func loadHostHeaders(filePath string) (HostHeaders, error) {
+	if filePath == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var headers HostHeaders
+	err = yaml.Unmarshal(b, &headers)
+	if err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// forHost returns the headers to send to host. When several domains match,
+// headers from the most specific domain take precedence.
+func (h HostHeaders) forHost(host string) http.Header {
+	host = strings.ToLower(host)
+	var domains []string
+	for domain := range h {
+		if matchesDomain(host, strings.ToLower(domain)) {
+			domains = append(domains, domain)
+		}
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		return len(domains[i]) < len(domains[j])
+	})
+
+	header := http.Header{}
+	for _, domain := range domains {
+		for k, v := range h[domain] {
+			header.Set(k, v)
+		}
+	}
+	return header
+}
+
+// matchesDomain reports whether host is domain or one of its subdomains.
+func matchesDomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// headerNames returns the names of the headers in h, without their values, for
+// logging.
+func headerNames(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for k := range h {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
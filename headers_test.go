@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHostHeadersForHost(t *testing.T) {
+	headers := HostHeaders{
+		"example.com": {
+			"Cookie":     "session=parent",
+			"User-Agent": "archiver",
+		},
+		"blog.example.com": {
+			"Cookie": "session=child",
+		},
+		"example.org": {
+			"Authorization": "Bearer xyz",
+		},
+	}
+	var tests = []struct {
+		name     string
+		host     string
+		expected http.Header
+	}{
+		{
+			"exact domain",
+			"example.com",
+			http.Header{"Cookie": {"session=parent"}, "User-Agent": {"archiver"}},
+		},
+		{
+			"most specific domain wins",
+			"blog.example.com",
+			http.Header{"Cookie": {"session=child"}, "User-Agent": {"archiver"}},
+		},
+		{
+			"subdomain",
+			"www.example.org",
+			http.Header{"Authorization": {"Bearer xyz"}},
+		},
+		{
+			"case insensitive",
+			"EXAMPLE.org",
+			http.Header{"Authorization": {"Bearer xyz"}},
+		},
+		{
+			"suffix is not a subdomain",
+			"notexample.com",
+			http.Header{},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			result := headers.forHost(tt.host)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("(%+v): expected %+v, got %+v", tt.host, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestLoadHostHeaders(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "headers.yaml")
+	err := os.WriteFile(filePath, []byte("example.com:\n  Cookie: session=abc\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers, err := loadHostHeaders(filePath)
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	expected := HostHeaders{"example.com": {"Cookie": "session=abc"}}
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("expected %+v, got %+v", expected, headers)
+	}
+}
+
+func TestHeaderNames(t *testing.T) {
+	result := headerNames(http.Header{"Cookie": {"secret"}, "Authorization": {"secret"}})
+	expected := "Authorization, Cookie"
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// latestSnapshot is the name of the symlink pointing to the most recent
+// snapshot of a link, when history is kept.
+const latestSnapshot = "latest"
+
+// snapshotTimeFormat is the layout of snapshot directory names. It avoids
+// colons so that the names are valid on all filesystems.
+const snapshotTimeFormat = "2006-01-02T15-04-05Z"
+
+// currentArchivePath returns the path of the current archive of linkID. When
+// history is kept, this is the archive in the latest snapshot.
+func (a *Archiver) currentArchivePath(linkID string) string {
+	filePath := archivePath(a.OutputDir, linkID, a.Format)
+	if !a.KeepHistory {
+		return filePath
+	}
+	return path.Join(a.OutputDir, linkID, latestSnapshot, path.Base(filePath))
+}
+
+// snapshotPath returns the path of the archive of linkID in the snapshot taken
+// at archivedAt.
+func (a *Archiver) snapshotPath(linkID string, archivedAt time.Time) string {
+	fileName := path.Base(archivePath(a.OutputDir, linkID, a.Format))
+	return path.Join(a.OutputDir, linkID, archivedAt.UTC().Format(snapshotTimeFormat), fileName)
+}
+
+// setLatestSnapshot points the latest symlink in linkDir at snapshot. The
+// symlink is replaced with a rename, so readers never see it missing.
+func setLatestSnapshot(linkDir, snapshot string) error {
+	tmpPath := path.Join(linkDir, ".latest.tmp")
+	os.Remove(tmpPath)
+	if err := os.Symlink(snapshot, tmpPath); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path.Join(linkDir, latestSnapshot)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// writeSnapshot writes the archive of linkID to a new snapshot at filePath,
+// then makes it the latest snapshot.
+func (a *Archiver) writeSnapshot(linkID, filePath string, r io.Reader) error {
+	linkDir := path.Join(a.OutputDir, linkID)
+	if err := os.MkdirAll(linkDir, 0755); err != nil {
+		return err
+	}
+	if err := writeArchive(a.OutputDir, filePath, r); err != nil {
+		return err
+	}
+	return setLatestSnapshot(linkDir, path.Base(path.Dir(filePath)))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestArchiveKeepHistory(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc](https://example.com/abc)\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkID, err := getLinkID("https://example.com/abc", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// seed an earlier snapshot of the link
+	linkDir := filepath.Join(outputDir, linkID)
+	oldSnapshot := "2020-01-01T00-00-00Z"
+	err = os.MkdirAll(filepath.Join(linkDir, oldSnapshot), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := "---\nurl: https://example.com/abc\narchived_at: 2020-01-01T00:00:00Z\ncontent_hash: old\n---\n<p>old</p>"
+	err = os.WriteFile(filepath.Join(linkDir, oldSnapshot, "index.html"), []byte(old), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setLatestSnapshot(linkDir, oldSnapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	fetched := 0
+	a := &Archiver{
+		InputDir:    inputDir,
+		OutputDir:   outputDir,
+		Refresh:     true,
+		KeepHistory: true,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			fetched++
+			return readability.Article{Title: "Example", Content: "<p>new</p>"}, nil
+		}),
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+
+	snapshots, err := filepath.Glob(filepath.Join(linkDir, "*", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the latest symlink also matches the glob
+	if len(snapshots) != 3 {
+		t.Fatalf("expected 2 snapshots and the latest symlink, got %+v", snapshots)
+	}
+	if _, err := os.Stat(filepath.Join(linkDir, oldSnapshot, "index.html")); err != nil {
+		t.Errorf("expected old snapshot to be kept, got %+v", err)
+	}
+	latest, err := os.Readlink(filepath.Join(linkDir, latestSnapshot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest == oldSnapshot {
+		t.Errorf("expected latest to point to the new snapshot, got %+v", latest)
+	}
+	b, err := os.ReadFile(filepath.Join(linkDir, latestSnapshot, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "<p>new</p>") {
+		t.Errorf("expected latest snapshot to hold new content, got %+v", string(b))
+	}
+
+	// an identical capture shouldn't create another snapshot
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if fetched != 2 {
+		t.Errorf("expected 2 fetches, got %d", fetched)
+	}
+	after, err := filepath.Glob(filepath.Join(linkDir, "*", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(snapshots) {
+		t.Errorf("expected no new snapshot, got %+v", after)
+	}
+
+	// the archive should be listed through its latest snapshot
+	archives, err := a.listArchives()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archives[linkID] != filepath.Join(outputDir, linkID) {
+		t.Errorf("expected archive of %+v to be listed, got %+v", linkID, archives)
+	}
+}
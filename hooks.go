@@ -0,0 +1,26 @@
+package main
+
+// notifyArchived calls the OnArchived hook, if set. Hooks are never called
+// concurrently, so they don't need to do their own locking.
+func (a *Archiver) notifyArchived(metadata Metadata, contentPath string) {
+	a.metrics.addArchived()
+	a.uncheckpointedArchives++
+	if a.OnArchived == nil {
+		return
+	}
+	a.hookMu.Lock()
+	defer a.hookMu.Unlock()
+	a.OnArchived(metadata, contentPath)
+}
+
+// notifyError calls the OnError hook, if set. Hooks are never called
+// concurrently, so they don't need to do their own locking.
+func (a *Archiver) notifyError(url string, err error) {
+	a.metrics.addFailure(url)
+	if a.OnError == nil {
+		return
+	}
+	a.hookMu.Lock()
+	defer a.hookMu.Unlock()
+	a.OnError(url, err)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestArchiveHooks(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	markdown := " [ok](https://example.com/ok)\n [fail](https://example.com/fail)\n"
+	err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetchErr := errors.New("fetch failed")
+	var archived []string
+	var archivedPaths []string
+	var failed []string
+	a := &Archiver{
+		InputDir:  inputDir,
+		OutputDir: outputDir,
+		OnArchived: func(metadata Metadata, contentPath string) {
+			archived = append(archived, metadata.URL)
+			archivedPaths = append(archivedPaths, contentPath)
+		},
+		OnError: func(url string, err error) {
+			if !errors.Is(err, fetchErr) {
+				t.Errorf("expected %+v, got %+v", fetchErr, err)
+			}
+			failed = append(failed, url)
+		},
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			if link == "https://example.com/fail" {
+				return readability.Article{}, fetchErr
+			}
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if !reflect.DeepEqual(archived, []string{"https://example.com/ok"}) {
+		t.Errorf("expected OnArchived for ok link, got %+v", archived)
+	}
+	linkID, err := getLinkID("https://example.com/ok", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPaths := []string{archivePath(outputDir, linkID, formatHTML)}
+	if !reflect.DeepEqual(archivedPaths, expectedPaths) {
+		t.Errorf("expected content paths %+v, got %+v", expectedPaths, archivedPaths)
+	}
+	if !reflect.DeepEqual(failed, []string{"https://example.com/fail"}) {
+		t.Errorf("expected OnError for failed link, got %+v", failed)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// imageExtensions are the extensions of links to images, which are not
+// archived from HTML files.
+var imageExtensions = map[string]bool{
+	".avif": true,
+	".bmp":  true,
+	".gif":  true,
+	".ico":  true,
+	".jpeg": true,
+	".jpg":  true,
+	".png":  true,
+	".svg":  true,
+	".webp": true,
+}
+
+func isHTMLFile(filePath string) bool {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	return ext == ".html" || ext == ".htm"
+}
+
+// parseLinksFromHTML returns the links in the <a href> elements of an HTML
+// document, along with the line each link appears on. Like markdown links,
+// only http and https links are returned, which leaves out in-page anchors and
+// mailto links. Links to images are also left out.
+func parseLinksFromHTML(content string) (links []markdownLink) {
+	z := html.NewTokenizer(strings.NewReader(content))
+	line := 1
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return links
+		}
+		tokenLine := line
+		line += strings.Count(string(z.Raw()), "\n")
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		token := z.Token()
+		if token.Data != "a" {
+			continue
+		}
+		for _, attr := range token.Attr {
+			if attr.Key != "href" {
+				continue
+			}
+			href := strings.TrimSpace(attr.Val)
+			u, err := url.Parse(href)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				continue
+			}
+			if imageExtensions[strings.ToLower(path.Ext(u.Path))] {
+				continue
+			}
+			links = append(links, markdownLink{URL: href, Line: tokenLine})
+		}
+	}
+}
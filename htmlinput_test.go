@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestParseLinksFromHTML(t *testing.T) {
+	content := `<html>
+<body>
+<p>A <a href="https://example.com/abc">link</a> and <a class="x"
+  href="http://example.com/def?a=1&amp;b=2">another</a>.</p>
+<a href="#section">anchor</a>
+<a href="mailto:someone@example.com">email</a>
+<a href="/relative">relative</a>
+<a href="https://example.com/image.PNG">image</a>
+<img src="https://example.com/photo.jpg">
+<a name="no-href">no href</a>
+</body>
+</html>`
+	expected := []markdownLink{
+		{URL: "https://example.com/abc", Line: 3},
+		{URL: "http://example.com/def?a=1&b=2", Line: 3},
+	}
+	result := parseLinksFromHTML(content)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestArchiveScanHTML(t *testing.T) {
+	var tests = []struct {
+		name     string
+		scanHTML bool
+		expected []string
+	}{
+		{"markdown only", false, []string{"https://example.com/md"}},
+		{"scan html", true, []string{"https://example.com/html", "https://example.com/htm", "https://example.com/md"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			files := map[string]string{
+				"notes.md":    " [md](https://example.com/md)\n",
+				"export.html": `<a href="https://example.com/html">html</a>`,
+				"old.htm":     `<a href="https://example.com/htm">htm</a>`,
+			}
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var fetched []string
+			a := &Archiver{
+				InputDir:  inputDir,
+				OutputDir: t.TempDir(),
+				ScanHTML:  tt.scanHTML,
+				Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+					fetched = append(fetched, link)
+					return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+				}),
+			}
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			sort.Strings(fetched)
+			sort.Strings(tt.expected)
+			if !reflect.DeepEqual(fetched, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, fetched)
+			}
+		})
+	}
+}
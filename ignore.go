@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFile is the name of the files listing links to exclude from
+// archiving. The patterns in an ignore file apply to the input files in its
+// directory and all subdirectories.
+//
+// Each line is a pattern, and blank lines and lines starting with # are
+// skipped. Patterns containing :// match the whole link, and other patterns
+// match the link's host. In both, * matches any sequence of characters, so
+// `https://example.com/private/*` ignores a path prefix and `*.example.com`
+// ignores all subdomains.
+const ignoreFile = ".archiveignore"
+
+// filterIgnored returns links, which were found in filePath, without the links
+// matched by the ignore files that apply to filePath.
+func (a *Archiver) filterIgnored(filePath string, links []markdownLink) ([]markdownLink, error) {
+	patterns, err := a.ignorePatterns(filepath.Dir(filePath))
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return links, nil
+	}
+	var filtered []markdownLink
+	for _, l := range links {
+		if !matchesIgnorePatterns(l.URL, patterns) {
+			filtered = append(filtered, l)
+		}
+	}
+	return filtered, nil
+}
+
+// ignorePatterns returns the patterns of the ignore files in dir and each of
+// its parents up to the input directory.
+func (a *Archiver) ignorePatterns(dir string) ([]ignorePattern, error) {
+	if a.ignoreCache == nil {
+		a.ignoreCache = make(map[string][]ignorePattern)
+	}
+	if patterns, ok := a.ignoreCache[dir]; ok {
+		return patterns, nil
+	}
+
+	var patterns []ignorePattern
+	rel, err := filepath.Rel(a.InputDir, dir)
+	if err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		parent, err := a.ignorePatterns(filepath.Dir(dir))
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, parent...)
+	}
+	own, err := loadIgnoreFile(filepath.Join(dir, ignoreFile))
+	if err != nil {
+		return nil, err
+	}
+	patterns = append(patterns, own...)
+	a.ignoreCache[dir] = patterns
+	return patterns, nil
+}
+
+// loadIgnoreFile returns the patterns in the ignore file at filePath. A
+// missing file has no patterns.
+func loadIgnoreFile(filePath string) ([]ignorePattern, error) {
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, err := compileIgnorePattern(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in %s: %v", line, filePath, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, scanner.Err()
+}
+
+// ignorePattern is a compiled ignore file pattern.
+type ignorePattern struct {
+	re *regexp.Regexp
+	// host reports whether the pattern matches hosts rather than links.
+	host bool
+}
+
+// compileIgnorePattern compiles an ignore file pattern, translating * into a
+// match of any sequence of characters.
+func compileIgnorePattern(pattern string) (ignorePattern, error) {
+	host := !strings.Contains(pattern, "://")
+	if host {
+		pattern = strings.ToLower(pattern)
+	}
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	re, err := regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+	if err != nil {
+		return ignorePattern{}, err
+	}
+	return ignorePattern{re: re, host: host}, nil
+}
+
+// matchesIgnorePatterns reports whether link matches any of patterns.
+func matchesIgnorePatterns(link string, patterns []ignorePattern) bool {
+	host := ""
+	if u, err := url.Parse(link); err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	for _, pattern := range patterns {
+		if pattern.host && pattern.re.MatchString(host) {
+			return true
+		} else if !pattern.host && pattern.re.MatchString(link) {
+			return true
+		}
+	}
+	return false
+}
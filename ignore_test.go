@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestMatchesIgnorePatterns(t *testing.T) {
+	var patterns []ignorePattern
+	for _, pattern := range []string{"https://example.com/exact", "https://example.com/private/*", "*.tracker.example", "Ads.Example"} {
+		compiled, err := compileIgnorePattern(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patterns = append(patterns, compiled)
+	}
+	var tests = []struct {
+		given    string
+		expected bool
+	}{
+		{"https://example.com/exact", true},
+		{"https://example.com/exact/more", false},
+		{"https://example.com/private/abc", true},
+		{"https://example.com/public/abc", false},
+		{"https://a.tracker.example/abc", true},
+		{"https://a.b.tracker.example/abc", true},
+		{"https://tracker.example/abc", false},
+		{"https://ads.example/abc", true},
+		{"https://ads.example.org/abc", false},
+	}
+	for _, tt := range tests {
+		result := matchesIgnorePatterns(tt.given, patterns)
+		if result != tt.expected {
+			t.Errorf("(%+v): expected %+v, got %+v", tt.given, tt.expected, result)
+		}
+	}
+}
+
+func TestArchiveIgnoreFiles(t *testing.T) {
+	inputDir := t.TempDir()
+	for _, dir := range []string{"work", "work/project", "personal"} {
+		if err := os.MkdirAll(filepath.Join(inputDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	markdown := " [a](https://a.example/abc)\n [b](https://b.example/abc)\n [c](https://c.example/abc)\n"
+	files := map[string]string{
+		"notes.md":                    markdown,
+		"work/notes.md":               markdown,
+		"work/project/notes.md":       markdown,
+		"personal/notes.md":           markdown,
+		"work/.archiveignore":         "# internal hosts\na.example\n",
+		"work/project/.archiveignore": "\nhttps://b.example/*\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fetched := make(map[string][]string)
+	a := &Archiver{
+		InputDir:  inputDir,
+		OutputDir: t.TempDir(),
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+		OnArchived: func(metadata Metadata, contentPath string) {
+			fetched[metadata.SourceFile] = append(fetched[metadata.SourceFile], metadata.URL)
+		},
+	}
+	// links are only archived once per run, so archive each file in its own
+	// run to see which links each file contributes
+	expected := map[string][]string{
+		"notes.md":              {"https://a.example/abc", "https://b.example/abc", "https://c.example/abc"},
+		"personal/notes.md":     {"https://a.example/abc", "https://b.example/abc", "https://c.example/abc"},
+		"work/notes.md":         {"https://b.example/abc", "https://c.example/abc"},
+		"work/project/notes.md": {"https://c.example/abc"},
+	}
+	for source := range expected {
+		a.OutputDir = t.TempDir()
+		a.ignoreCache = nil
+		a.processedLinks = make(map[string]bool)
+		if err := a.processLinksInFile(context.Background(), filepath.Join(inputDir, source)); err != nil {
+			t.Fatalf("expected nil error, got %+v", err)
+		}
+	}
+	for source := range fetched {
+		sort.Strings(fetched[source])
+	}
+	if !reflect.DeepEqual(fetched, expected) {
+		t.Errorf("expected %+v, got %+v", expected, fetched)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Errors of -input-glob.
+var (
+	ErrInputConflict    = errors.New("input and input-glob cannot be combined")
+	ErrInvalidInputGlob = errors.New("invalid input-glob")
+)
+
+// validateInputGlob checks that pattern is well-formed, so that a typo fails
+// up front rather than matching no files.
+func validateInputGlob(pattern string) error {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidInputGlob, pattern, err)
+	}
+	return nil
+}
+
+// globBase returns the directory pattern starts at, which is its longest
+// leading path without glob metacharacters.
+func globBase(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for strings.ContainsAny(dir, `*?[\`) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// walkInputGlob calls fn for each input file matching InputGlob, in lexical
+// order. Matched directories are skipped rather than walked.
+func (a *Archiver) walkInputGlob(fn func(filePath string, info os.FileInfo) error) error {
+	matches, err := filepath.Glob(a.InputGlob)
+	if err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidInputGlob, a.InputGlob, err)
+	}
+	for _, filePath := range matches {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && a.isInputFile(filePath) {
+			if err := fn(filePath, info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isInputGlobMatch reports whether filePath matches InputGlob, if it is set.
+func (a *Archiver) isInputGlobMatch(filePath string) bool {
+	if a.InputGlob == "" {
+		return true
+	}
+	matched, err := filepath.Match(filepath.Clean(a.InputGlob), filepath.Clean(filePath))
+	return err == nil && matched
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestGlobBase(t *testing.T) {
+	var tests = []struct {
+		given    string
+		expected string
+	}{
+		{"notes/2024-*.md", "notes"},
+		{"notes/*/index.md", "notes"},
+		{"./notes/journal/[0-9]*.md", "notes/journal"},
+		{"*.md", "."},
+		{"/home/notes/*.md", "/home/notes"},
+		{"notes/2024.md", "notes"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.given, func(t *testing.T) {
+			if result := globBase(tt.given); result != tt.expected {
+				t.Errorf("(%+v): expected %+v, got %+v", tt.given, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestArchiveInputGlob(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	files := map[string]string{
+		"2024-01.md":          " [a](https://example.com/a)\n",
+		"2024-02.md":          " [b](https://example.com/b)\n",
+		"2023-12.md":          " [c](https://example.com/c)\n",
+		"2024-03.txt":         " [d](https://example.com/d)\n",
+		"2024-dir/2024-04.md": " [e](https://example.com/e)\n",
+	}
+	for name, content := range files {
+		filePath := filepath.Join(inputDir, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var fetched []string
+	pattern := filepath.Join(inputDir, "2024-*")
+	a := &Archiver{
+		InputDir:    globBase(pattern),
+		InputGlob:   pattern,
+		OutputDir:   outputDir,
+		Incremental: true,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			fetched = append(fetched, link)
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+		stdout: &bytes.Buffer{},
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	sort.Strings(fetched)
+	expected := []string{"https://example.com/a", "https://example.com/b"}
+	if len(fetched) != len(expected) || fetched[0] != expected[0] || fetched[1] != expected[1] {
+		t.Errorf("expected links of matching markdown files %+v, got %+v", expected, fetched)
+	}
+	// matched files are still tracked relative to the input directory
+	if _, ok := a.processedFiles["2024-01.md"]; !ok {
+		t.Errorf("expected processed file relative to input directory, got %+v", a.processedFiles)
+	}
+
+	if _, err := a.Prune(false); err == nil {
+		t.Errorf("expected error pruning with an input glob")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// defaultMaxConnsPerHost is the number of connections kept open to each host
+// when none is configured. Each connection uses a file descriptor, so the
+// pool is kept small; it can be raised with -max-conns-per-host when
+// archiving many links from the same host.
+const defaultMaxConnsPerHost = 4
+
+// maxIdleConns is the total number of idle connections kept for reuse across
+// all hosts.
+const maxIdleConns = 64
+
+// maxOpenFiles bounds the number of archive files being written at once, so
+// that output files don't compete with connections for file descriptors.
+const maxOpenFiles = 32
+
+// openFiles holds a token for each archive file being written.
+var openFiles = make(chan struct{}, maxOpenFiles)
+
+// File creation is retried with exponential backoff when the process runs
+// out of file descriptors, since descriptors are freed as requests finish.
+const (
+	createRetries      = 5
+	createRetryBackoff = 50 * time.Millisecond
+)
+
+// createTemp creates a temporary file. It can be replaced in tests.
+var createTemp = os.CreateTemp
+
+// createTempWithRetry is like os.CreateTemp, but retries when there are too
+// many open files rather than failing the run.
+func createTempWithRetry(dir, pattern string) (*os.File, error) {
+	backoff := createRetryBackoff
+	for i := 0; ; i++ {
+		f, err := createTemp(dir, pattern)
+		if err == nil || !isTooManyOpenFiles(err) || i == createRetries {
+			return f, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isTooManyOpenFiles reports whether err is caused by the process or system
+// running out of file descriptors.
+func isTooManyOpenFiles(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+func (a *Archiver) maxConnsPerHost() int {
+	if a.MaxConnsPerHost > 0 {
+		return a.MaxConnsPerHost
+	}
+	return defaultMaxConnsPerHost
+}
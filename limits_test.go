@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestWriteArchiveTooManyOpenFiles(t *testing.T) {
+	var tests = []struct {
+		name          string
+		errs          []error
+		expectedCalls int
+		expectErr     bool
+	}{
+		{"retried", []error{&os.PathError{Op: "open", Err: syscall.EMFILE}, &os.PathError{Op: "open", Err: syscall.ENFILE}}, 3, false},
+		{"other errors not retried", []error{&os.PathError{Op: "open", Err: syscall.EACCES}}, 1, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			createTemp = func(dir, pattern string) (*os.File, error) {
+				calls++
+				if calls <= len(tt.errs) {
+					return nil, tt.errs[calls-1]
+				}
+				return os.CreateTemp(dir, pattern)
+			}
+			t.Cleanup(func() { createTemp = os.CreateTemp })
+
+			outputDir := t.TempDir()
+			filePath := filepath.Join(outputDir, "abc", "index.html")
+			err := writeArchive(outputDir, filePath, strings.NewReader("content"))
+			if tt.expectErr && err == nil {
+				t.Error("expected error, got nil")
+			} else if !tt.expectErr && err != nil {
+				t.Errorf("expected nil error, got %+v", err)
+			}
+			if calls != tt.expectedCalls {
+				t.Errorf("expected %d calls, got %d", tt.expectedCalls, calls)
+			}
+		})
+	}
+}
+
+func TestHTTPClientConnectionLimits(t *testing.T) {
+	a := &Archiver{MaxConnsPerHost: 8, AllowPrivateIPs: true}
+	transport, ok := a.httpClient().Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("expected *http.Transport, got %T", a.httpClient().Transport)
+	}
+	if transport.MaxConnsPerHost != 8 || transport.MaxIdleConnsPerHost != 8 {
+		t.Errorf("expected 8 connections per host, got %d (%d idle)", transport.MaxConnsPerHost, transport.MaxIdleConnsPerHost)
+	}
+	if transport.MaxIdleConns != maxIdleConns {
+		t.Errorf("expected %d idle connections, got %d", maxIdleConns, transport.MaxIdleConns)
+	}
+}
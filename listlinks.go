@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sort"
+)
+
+// ListLinks returns the sorted, deduplicated links found in the input, as
+// they would be archived. Links that share an archive once normalized are
+// listed as they are first written. It doesn't fetch anything or touch the
+// output directory.
+func (a *Archiver) ListLinks() ([]string, error) {
+	// links by their normalized form
+	seen := make(map[string]string)
+	a.ignoreCache = nil
+	add := func(source string, links []markdownLink) {
+		for _, l := range links {
+			normalized, err := a.normalizeLink(l.URL)
+			if err != nil {
+				a.logf(logEvent{Event: eventWarning, URL: l.URL, SourceFile: source, Line: l.Line, Err: err}, "cannot normalize link %+v (%s:%d): %+v", l.URL, source, l.Line, err)
+			}
+			if !a.AllowLocal && a.isLocalLink(l.URL) {
+				continue
+			}
+			if _, ok := seen[normalized]; !ok {
+				seen[normalized] = l.URL
+			}
+		}
+	}
+
+	if a.InputDir == stdinInput {
+		b, err := io.ReadAll(a.stdinReader())
+		if err != nil {
+			return nil, err
+		}
+		add(stdinSource, parseLinksFromMarkdownWithPositions(string(b)))
+	} else {
+		err := a.walkInputFiles(func(filePath string, info os.FileInfo) error {
+			links, err := a.readLinksInFile(filePath)
+			if err != nil {
+				return err
+			}
+			links, err = a.filterIgnored(filePath, links)
+			if err != nil {
+				return err
+			}
+			add(a.relativeInputPath(filePath), links)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	links := make([]string, 0, len(seen))
+	for _, link := range seen {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+	return links, nil
+}
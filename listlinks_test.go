@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListLinks(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := filepath.Join(t.TempDir(), "missing")
+	if err := os.Mkdir(filepath.Join(inputDir, "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"b.md":        " [b](https://example.com/b)\n [a](https://www.example.com/a?utm_source=feed)\n",
+		"nested/a.md": " [a](https://example.com/a)\n [local](http://localhost/abc)\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := &Archiver{
+		InputDir:  inputDir,
+		OutputDir: outputDir,
+		Normalize: true,
+	}
+	links, err := a.ListLinks()
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	expected := []string{"https://example.com/b", "https://www.example.com/a?utm_source=feed"}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("expected %+v, got %+v", expected, links)
+	}
+	if _, err := os.Stat(outputDir); !os.IsNotExist(err) {
+		t.Errorf("expected output directory to be untouched, got %+v", err)
+	}
+}
+
+func TestListLinksStdin(t *testing.T) {
+	a := &Archiver{
+		InputDir: stdinInput,
+		stdin:    strings.NewReader(" [b](https://example.com/b)\n [a](https://example.com/a)\n [b](https://example.com/b)\n"),
+	}
+	links, err := a.ListLinks()
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	expected := []string{"https://example.com/a", "https://example.com/b"}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("expected %+v, got %+v", expected, links)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// isLocalLink reports whether link points to a file, the local machine, a
+// private network, or the archives in the output directory as served at
+// SitemapBaseURL. Such links are skipped unless AllowLocal is set, so that the
+// archiver doesn't try to archive its own output or internal services.
+func (a *Archiver) isLocalLink(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "file" {
+		return true
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && isPrivateIP(ip) {
+		return true
+	}
+	if a.SitemapBaseURL != "" {
+		base, err := url.Parse(a.SitemapBaseURL)
+		if err == nil && strings.EqualFold(base.Host, u.Host) &&
+			strings.HasPrefix(u.Path, strings.TrimRight(base.Path, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivateIP reports whether ip is a loopback, private, link-local or
+// unspecified address.
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+}
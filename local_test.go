@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestIsLocalLink(t *testing.T) {
+	var tests = []struct {
+		given    string
+		expected bool
+	}{
+		{"https://example.com/abc", false},
+		{"file:///etc/passwd", true},
+		{"http://localhost:8080/abc", true},
+		{"http://LOCALHOST/abc", true},
+		{"http://app.localhost/abc", true},
+		{"http://127.0.0.1/abc", true},
+		{"http://10.0.0.1/abc", true},
+		{"http://172.16.5.4/abc", true},
+		{"http://192.168.1.1/abc", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"http://0.0.0.0/abc", true},
+		{"http://[::1]/abc", true},
+		{"http://[fd00::1]/abc", true},
+		{"http://8.8.8.8/abc", false},
+		{"https://archive.example.org/links/example.com__abc/index.html", true},
+		{"https://archive.example.org/links", false},
+		{"https://archive.example.org/other/abc", false},
+	}
+	a := &Archiver{SitemapBaseURL: "https://archive.example.org/links/"}
+	for _, tt := range tests {
+		result := a.isLocalLink(tt.given)
+		if result != tt.expected {
+			t.Errorf("(%+v): expected %+v, got %+v", tt.given, tt.expected, result)
+		}
+	}
+}
+
+func TestArchiveAllowLocal(t *testing.T) {
+	var tests = []struct {
+		name       string
+		allowLocal bool
+		expected   []string
+	}{
+		{"skip local links", false, []string{"https://example.com/abc"}},
+		{"allow local links", true, []string{"https://example.com/abc", "http://localhost:8080/abc"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			markdown := " [abc](https://example.com/abc)\n [local](http://localhost:8080/abc)\n"
+			err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fetched []string
+			a := &Archiver{
+				InputDir:   inputDir,
+				OutputDir:  t.TempDir(),
+				AllowLocal: tt.allowLocal,
+				Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+					fetched = append(fetched, link)
+					return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+				}),
+			}
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			if !reflect.DeepEqual(fetched, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, fetched)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// lockFile is the name of the file in the output directory that is held
+// while a run writes to it, so that overlapping runs don't race each other.
+const lockFile = ".archiver.lock"
+
+const (
+	// lockHeartbeat is how often a held lock is touched to show that its
+	// run is still alive.
+	lockHeartbeat = 10 * time.Second
+	// lockStaleAfter is how long after its last heartbeat a lock is
+	// considered left behind by a crashed run.
+	lockStaleAfter = 6 * lockHeartbeat
+	// lockPollInterval is how often a held lock is retried when waiting
+	// for it.
+	lockPollInterval = 250 * time.Millisecond
+)
+
+// ErrLocked is returned when the output directory is locked by another run.
+var ErrLocked = errors.New("output directory is locked by another run")
+
+// outputLock is a held lock on the output directory.
+type outputLock struct {
+	path string
+	stop chan struct{}
+	done chan struct{}
+}
+
+// acquireLock locks the output directory for the rest of a run, waiting for
+// the lock if WaitLock is set, and returns a function that releases it. A
+// lock already held by a is not acquired again, so that runs within a watch
+// share its lock.
+func (a *Archiver) acquireLock(ctx context.Context) (release func(), err error) {
+	if a.lock != nil {
+		return func() {}, nil
+	}
+	lockPath := path.Join(a.OutputDir, lockFile)
+	for {
+		err = createLockFile(lockPath)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		holder, stale := readLock(lockPath)
+		if stale {
+			a.logf(logEvent{Event: eventWarning}, "removing stale lock %s (%s)", lockPath, holder)
+			if err := os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue
+		}
+		if !a.WaitLock {
+			return nil, fmt.Errorf("%w (%s)", ErrLocked, holder)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	lock := &outputLock{path: lockPath, stop: make(chan struct{}), done: make(chan struct{})}
+	go lock.heartbeat()
+	a.lock = lock
+	return func() {
+		close(lock.stop)
+		<-lock.done
+		os.Remove(lock.path)
+		a.lock = nil
+	}, nil
+}
+
+// heartbeat touches the lock file until the lock is released.
+func (l *outputLock) heartbeat() {
+	defer close(l.done)
+	ticker := time.NewTicker(lockHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			os.Chtimes(l.path, now, now)
+		}
+	}
+}
+
+// createLockFile creates the lock file at lockPath, recording the process
+// holding it. It fails with os.ErrExist if the lock is already held.
+func createLockFile(lockPath string) error {
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	_, err = fmt.Fprintf(f, "%d %s\n", os.Getpid(), hostname)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(lockPath)
+	}
+	return err
+}
+
+// readLock describes the holder of the lock at lockPath, and reports whether
+// the lock is stale: either its process is no longer running on this host,
+// or it hasn't had a heartbeat within lockStaleAfter.
+func readLock(lockPath string) (holder string, stale bool) {
+	info, err := os.Stat(lockPath)
+	if err != nil {
+		// the lock was released in the meantime
+		return "released", os.IsNotExist(err)
+	}
+	if time.Since(info.ModTime()) > lockStaleAfter {
+		return "last heartbeat " + info.ModTime().Format(time.RFC3339), true
+	}
+	b, err := os.ReadFile(lockPath)
+	if err != nil {
+		return "unreadable lock file", false
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) != 2 {
+		// the holder may not have finished writing the lock file
+		return "unknown process", false
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return "unknown process", false
+	}
+	holder = fmt.Sprintf("pid %d on %s", pid, fields[1])
+	if hostname, _ := os.Hostname(); fields[1] == hostname && !isProcessRunning(pid) {
+		return holder, true
+	}
+	return holder, false
+}
+
+// isProcessRunning reports whether a process with the given pid is running.
+func isProcessRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestLock writes a lock file to outputDir held by pid on hostname, with
+// its last heartbeat at heartbeat.
+func writeTestLock(t *testing.T, outputDir string, pid int, hostname string, heartbeat time.Time) {
+	t.Helper()
+	lockPath := filepath.Join(outputDir, lockFile)
+	if err := os.WriteFile(lockPath, []byte(fmt.Sprintf("%d %s\n", pid, hostname)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(lockPath, heartbeat, heartbeat); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveLocked(t *testing.T) {
+	outputDir := t.TempDir()
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// this process is running, so the lock is held
+	writeTestLock(t, outputDir, os.Getpid(), hostname, time.Now())
+	a := &Archiver{InputDir: t.TempDir(), OutputDir: outputDir}
+	err = a.Archive()
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected error %+v, got %+v", ErrLocked, err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, ".checked_links.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected locked run not to write caches, got %+v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, lockFile)); err != nil {
+		t.Errorf("expected lock of the other run to be kept, got %+v", err)
+	}
+}
+
+func TestArchiveStaleLock(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name      string
+		hostname  string
+		heartbeat time.Time
+	}{
+		{"missed heartbeat", "other-host", time.Now().Add(-2 * lockStaleAfter)},
+		{"process not running", hostname, time.Now()},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			outputDir := t.TempDir()
+			// pids are far below this on any real system
+			writeTestLock(t, outputDir, 1<<30, tt.hostname, tt.heartbeat)
+			a := &Archiver{InputDir: t.TempDir(), OutputDir: outputDir}
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			if _, err := os.Stat(filepath.Join(outputDir, lockFile)); !os.IsNotExist(err) {
+				t.Errorf("expected lock to be released, got %+v", err)
+			}
+		})
+	}
+}
+
+func TestArchiveWaitLock(t *testing.T) {
+	outputDir := t.TempDir()
+	holder := &Archiver{OutputDir: outputDir}
+	release, err := holder.acquireLock(context.Background())
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	time.AfterFunc(2*lockPollInterval, release)
+
+	a := &Archiver{InputDir: t.TempDir(), OutputDir: outputDir, WaitLock: true}
+	start := time.Now()
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if time.Since(start) < 2*lockPollInterval {
+		t.Errorf("expected run to wait for the lock to be released")
+	}
+
+	// waiting gives up when the run is stopped
+	release, err = (&Archiver{OutputDir: outputDir}).acquireLock(context.Background())
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), lockPollInterval)
+	defer cancel()
+	if err := a.ArchiveContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error %+v, got %+v", context.DeadlineExceeded, err)
+	}
+}
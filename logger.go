@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Log formats.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Events logged during a run.
+const (
+	eventArchived = "archived"
+	eventSkipped  = "skipped"
+	eventFailed   = "failed"
+	// eventRetried is a link fetched again by rendering it after a plain
+	// fetch failed or captured too little content.
+	eventRetried = "retried"
+	eventStopped = "stopped"
+	eventRequest = "request"
+	// eventServing is the HTTP API starting to accept requests.
+	eventServing = "serving"
+	// eventWarning is a problem that doesn't fail the link or run.
+	eventWarning = "warning"
+	eventFatal   = "fatal"
+)
+
+// logEvent is an event logged during a run.
+type logEvent struct {
+	Event      string
+	URL        string
+	LinkID     string
+	SourceFile string
+	Line       int
+	Duration   time.Duration
+	Err        error
+}
+
+// as returns a copy of e as event, failed with err if it isn't nil.
+func (e logEvent) as(event string, err error) logEvent {
+	e.Event = event
+	e.Err = err
+	return e
+}
+
+// jsonLogEvent is the encoding of a logEvent in the JSON log format.
+type jsonLogEvent struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	URL        string    `json:"url,omitempty"`
+	LinkID     string    `json:"link_id,omitempty"`
+	SourceFile string    `json:"source_file,omitempty"`
+	Line       int       `json:"line,omitempty"`
+	DurationMS *int64    `json:"duration_ms,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Message    string    `json:"message,omitempty"`
+}
+
+// logf logs event. In the text log format the message, formatted from format
+// and args, is printed, and events without a message aren't. In the JSON log
+// format the event is printed as a JSON object on a single line, including
+// the message. Archived links are logged to stdout and all other events to
+// stderr.
+func (a *Archiver) logf(event logEvent, format string, args ...interface{}) {
+	w := a.stderrWriter()
+	if event.Event == eventArchived {
+		w = a.stdoutWriter()
+	}
+	var message string
+	if format != "" {
+		message = fmt.Sprintf(format, args...)
+	}
+	if a.LogFormat == logFormatJSON {
+		writeJSONLogEvent(w, event, message)
+	} else if message != "" {
+		fmt.Fprintln(w, message)
+	}
+}
+
+// writeJSONLogEvent writes event, with its message, to w in the JSON log
+// format.
+func writeJSONLogEvent(w io.Writer, event logEvent, message string) {
+	e := jsonLogEvent{
+		Time:       time.Now().UTC(),
+		Event:      event.Event,
+		URL:        event.URL,
+		LinkID:     event.LinkID,
+		SourceFile: event.SourceFile,
+		Line:       event.Line,
+		Message:    message,
+	}
+	if event.Duration > 0 {
+		ms := event.Duration.Milliseconds()
+		e.DurationMS = &ms
+	}
+	if event.Err != nil {
+		e.Error = event.Err.Error()
+	}
+	// none of the fields can fail to encode
+	b, _ := json.Marshal(e)
+	// a single write, so that concurrent events don't interleave
+	w.Write(append(b, '\n'))
+}
+
+// fatal logs err in the format given by -log-format and exits with the
+// status given by exitCode.
+func fatal(err error) {
+	if *logFormat != logFormatJSON {
+		log.Print(err)
+	} else {
+		writeJSONLogEvent(os.Stderr, logEvent{Event: eventFatal, Err: err}, err.Error())
+	}
+	os.Exit(exitCode(err))
+}
+
+// exitCode returns the exit status of a run that ended with err.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrLinksFailed):
+		return exitLinksFailed
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitMaxDurationExceeded
+	case errors.Is(err, ErrMaxLinksReached):
+		return exitMaxLinksReached
+	default:
+		return exitFatal
+	}
+}
+
+func (a *Archiver) stdoutWriter() io.Writer {
+	if a.stdout != nil {
+		return a.stdout
+	}
+	return os.Stdout
+}
+
+func (a *Archiver) stderrWriter() io.Writer {
+	if a.stderr != nil {
+		return a.stderr
+	}
+	return os.Stderr
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+// parseJSONLog parses log, which is in the JSON log format.
+func parseJSONLog(t *testing.T, log string) []map[string]interface{} {
+	t.Helper()
+	var events []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSuffix(log, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("expected a JSON object per line, got %q: %+v", line, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestArchiveLogFormat(t *testing.T) {
+	inputDir := t.TempDir()
+	markdown := " [ok](https://example.com/ok)\n [failed](https://example.com/failed)\n"
+	if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fetcher := FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+		if link == "https://example.com/failed" {
+			return readability.Article{}, errors.New("fetch failed")
+		}
+		return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+	})
+	okID, err := getLinkID("https://example.com/ok", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("text", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		a := &Archiver{InputDir: inputDir, OutputDir: t.TempDir(), Fetcher: fetcher, stdout: &stdout, stderr: &stderr}
+		for i := 0; i < 2; i++ {
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+		}
+		if expected := "Archived https://example.com/ok\n"; stdout.String() != expected {
+			t.Errorf("expected stdout %q, got %q", expected, stdout.String())
+		}
+		// skipped links have no message, so only the failure is printed
+		if expected := "cannot apply readability for https://example.com/failed (notes.md:2): fetch failed\n"; stderr.String() != expected {
+			t.Errorf("expected stderr %q, got %q", expected, stderr.String())
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		a := &Archiver{InputDir: inputDir, OutputDir: t.TempDir(), Fetcher: fetcher, LogFormat: logFormatJSON, stdout: &stdout, stderr: &stderr}
+		for i := 0; i < 2; i++ {
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+		}
+
+		archived := parseJSONLog(t, stdout.String())
+		if len(archived) != 1 {
+			t.Fatalf("expected 1 event on stdout, got %+v", archived)
+		}
+		expected := map[string]interface{}{
+			"event":       eventArchived,
+			"url":         "https://example.com/ok",
+			"link_id":     okID,
+			"source_file": "notes.md",
+			"line":        float64(1),
+			"message":     "Archived https://example.com/ok",
+		}
+		for k, v := range expected {
+			if archived[0][k] != v {
+				t.Errorf("expected %s %+v, got %+v", k, v, archived[0][k])
+			}
+		}
+		if _, ok := archived[0]["duration_ms"]; !ok {
+			t.Errorf("expected duration_ms, got %+v", archived[0])
+		}
+
+		var events []string
+		for _, event := range parseJSONLog(t, stderr.String()) {
+			events = append(events, event["event"].(string)+" "+event["url"].(string))
+			if event["event"] == eventFailed && event["error"] != "fetch failed" {
+				t.Errorf("expected error of failed link, got %+v", event)
+			}
+		}
+		expectedEvents := []string{
+			"failed https://example.com/failed",
+			"skipped https://example.com/ok",
+			"skipped https://example.com/failed",
+		}
+		if strings.Join(events, "\n") != strings.Join(expectedEvents, "\n") {
+			t.Errorf("expected events %+v on stderr, got %+v", expectedEvents, events)
+		}
+	})
+}
+
+func TestExitCode(t *testing.T) {
+	var tests = []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"success", nil, 0},
+		{"links failed", fmt.Errorf("%w: 2 failed", ErrLinksFailed), exitLinksFailed},
+		{"max duration exceeded", context.DeadlineExceeded, exitMaxDurationExceeded},
+		{"max links reached", ErrMaxLinksReached, exitMaxLinksReached},
+		{"fatal", ErrOutputNotExist, exitFatal},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if result := exitCode(tt.err); result != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, result)
+			}
+		})
+	}
+}
@@ -1,132 +1,969 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
-	"github.com/go-shiori/go-readability"
 	"gopkg.in/yaml.v2"
 )
 
-// NOTE: regex has an edge case where it won't match a string starting with a
-// valid link. Must have at least one character between the start of line and
-// the link.
-//
-// [^!]                                 - Don't match if starts with `!` (link is an image)
-//     \[[^][]+\]                       - 1+ occurances of non-][ character
-//               \(                     - Opening brace containing the URL
-//		   (https?://           - Capture group: http:// or https://
-//                           [^()]+)    - 1+ characters of non-)( character. End of capture group
-//                                  \)  - Closing brace containing the URL
-var markdownLinkRegex = regexp.MustCompile(`[^!]\[[^][]+]\((https?://[^()]+)\)`)
+const (
+	// stdinInput is the input directory that reads markdown from stdin.
+	stdinInput = "-"
+	// stdinSource is the source file recorded for markdown read from stdin.
+	stdinSource = "<stdin>"
+)
+
+// Exit statuses of unsuccessful runs.
+const (
+	// exitFatal is the exit status when a run cannot complete.
+	exitFatal = 1
+	// exitLinksFailed is the exit status when a run completes, but some
+	// links couldn't be archived and -fail-on-error is set.
+	exitLinksFailed = 2
+	// exitMaxDurationExceeded is the exit status when a run is stopped for
+	// exceeding -max-duration.
+	exitMaxDurationExceeded = 3
+	// exitMaxLinksReached is the exit status when a run is stopped for
+	// reaching -max-links.
+	exitMaxLinksReached = 4
+)
 
+// Errors returned by a run that ends unsuccessfully, which exitCode maps to
+// their exit statuses.
 var (
-	inputDir  = flag.String("input", "", "Path to input directory")
-	outputDir = flag.String("output", "", "Path to output directory")
+	// ErrLinksFailed is returned when a run completes, but some links
+	// couldn't be archived and FailOnError is set.
+	ErrLinksFailed = errors.New("some links could not be archived")
+	// ErrMaxLinksReached is returned when a run is stopped for reaching
+	// MaxLinks.
+	ErrMaxLinksReached = errors.New("reached the maximum number of links to archive in a run")
+)
+
+// defaultMaxIDLength is the maximum length of a link ID, excluding the
+// appended hash, used when none is configured.
+const defaultMaxIDLength = 100
+
+// defaultHashLength is the number of hex characters of the link hash appended
+// to link IDs, used when none is configured. maxHashLength is the length of
+// the full hash.
+const (
+	defaultHashLength = 8
+	maxHashLength     = sha256.Size * 2
+)
+
+var (
+	inputDir           = flag.String("input", "", "Path to input directory, or - to read markdown from stdin")
+	inputGlob          = flag.String("input-glob", "", "Archive links only from the files matching this glob, e.g. 'notes/2024-*.md', instead of an input directory")
+	outputDir          = flag.String("output", "", "Path to output directory")
+	createOutput       = flag.Bool("create-output", false, "Create the output directory if it doesn't exist")
+	refresh            = flag.Bool("refresh", false, "Re-archive links that have already been archived")
+	maxIDLength        = flag.Int("max-id-length", defaultMaxIDLength, "Maximum length of a link ID, excluding the appended hash")
+	hashLength         = flag.Int("hash-length", defaultHashLength, "Number of hex characters of the link hash appended to link IDs")
+	normalize          = flag.Bool("normalize", false, "Normalize links before computing their archive IDs so that variants of a URL share one archive")
+	stripParams        = flag.String("strip-params", strings.Join(defaultStripParams, ","), "Comma-separated query parameters to strip when normalizing links. A trailing * matches a prefix")
+	domainRulesFile    = flag.String("domain-rules", "", "Path to a YAML file mapping domains to overrides of timeout, user_agent, render_js, and headers")
+	headersFile        = flag.String("headers-file", "", "Path to a YAML file mapping domains to additional HTTP headers to send")
+	verbose            = flag.Bool("verbose", false, "Print verbose output")
+	format             = flag.String("format", formatHTML, "Output format of archived content: html, md, or singlefile, which inlines the images and stylesheets of the content into a single file")
+	extractorName      = flag.String("extractor", extractorReadability, "Extractor of the content of pages: readability for the main article, raw for the whole body without scripts and styles, or none for the body as fetched")
+	saveFavicon        = flag.Bool("save-favicon", false, "Save the favicon of each archived page as favicon.ico")
+	tagHeadings        = flag.Bool("tag-headings", false, "Tag archives with the markdown headings that links appear under")
+	minContentLength   = flag.Int("min-content-length", 1, "Minimum length in bytes of captured content. Shorter captures are not archived and are retried on the next run")
+	renderJS           = flag.Bool("render-js", false, "Render pages in headless Chrome when a plain fetch captures too little content")
+	prune              = flag.Bool("prune", false, "Report archives that no longer correspond to a link in the input directory, instead of archiving")
+	rebuildCache       = flag.Bool("rebuild-cache", false, "Regenerate the caches in the output directory from its archives, instead of archiving. The input directory isn't needed")
+	pruneDelete        = flag.Bool("prune-delete", false, "Delete archives that no longer correspond to a link in the input directory. Implies -prune")
+	incremental        = flag.Bool("incremental", false, "Skip markdown files that haven't been modified since the last run")
+	force              = flag.Bool("force", false, "Process all markdown files, even when running incrementally")
+	useCanonical       = flag.Bool("canonical", false, "Archive pages under the canonical URL they declare, when it is on the same site")
+	sharedCache        = flag.String("shared-cache", "", "Comma-separated checked link caches of other output directories, or the directories themselves, whose links are skipped. They are only read")
+	skipContentTypes   = flag.String("skip-content-types", "", "Comma-separated content types of responses to skip rather than archive, e.g. application/json,image/*")
+	maxPageBytes       = flag.Int64("max-page-bytes", 0, "Skip archiving pages whose archived file, including inlined images, is larger than this many bytes, and list them in .too_large_links.txt. Zero means no limit")
+	logFormat          = flag.String("log-format", logFormatText, "Format of logged events: text, or json for one JSON object per line")
+	trustFilesystem    = flag.Bool("trust-filesystem", false, "Skip links that have an archive in the output directory, ignoring the checked link cache")
+	waitLock           = flag.Bool("wait-lock", false, "Wait for another run using the output directory to finish, instead of exiting")
+	debugLogs          = flag.Bool("debug-logs", false, "Append a record of each fetch, with its timing, final URL, and status, to archive.log in the link directory")
+	gitDiff            = flag.String("git-diff", "", "Only archive links on lines added since this git ref, e.g. origin/main, including uncommitted changes. Falls back to all files outside a git repository")
+	since              = flag.String("since", "", "Only process input files modified within this duration, e.g. 24h or 7d, or since this date, e.g. 2006-01-02")
+	maxDuration        = flag.Duration("max-duration", 0, "Stop archiving after this long, e.g. 10m. Zero means no limit")
+	serveAddr          = flag.String("serve", "", "Serve an HTTP API on this address, e.g. :8080, that archives links on request with POST /archive, instead of archiving the input")
+	watch              = flag.Bool("watch", false, "Keep running, and archive links in input files as they are created or changed")
+	checkpointArchives = flag.Int("checkpoint-archives", 0, "Write the caches after every this many links archived, so that an interrupted run keeps its progress. Zero writes them only at the end of the run")
+	checkpointInterval = flag.Duration("checkpoint-interval", 0, "Write the caches at least this often during a run, e.g. 1m. Zero writes them only at the end of the run")
+	maxLinks           = flag.Int("max-links", 0, fmt.Sprintf("Stop archiving, with exit status %d, before fetching more than this many links in a run. Zero means no limit", exitMaxLinksReached))
+	failOnError        = flag.Bool("fail-on-error", false, fmt.Sprintf("Exit with status %d if any links couldn't be archived", exitLinksFailed))
+	metricsFile        = flag.String("metrics-file", "", "Write metrics of each run to this file in the Prometheus text format, e.g. for node_exporter's textfile collector")
+	listLinks          = flag.Bool("list-links", false, "Print the links found in the input, one per line, without archiving them")
+	maxConnsPerHost    = flag.Int("max-conns-per-host", defaultMaxConnsPerHost, "Maximum number of connections to each host. Raise this when archiving many links from one host; each connection uses a file descriptor")
+	allowPrivateIPs    = flag.Bool("allow-private-ips", false, "Allow requests to hosts that resolve to private, loopback, or link-local addresses")
+	allowLocal         = flag.Bool("allow-local", false, "Archive links to localhost, private IP addresses, and the output directory, which are skipped by default")
+	frontmatterFields  = flag.String("frontmatter-fields", "", "Comma-separated fields of the YAML frontmatter of markdown files whose URLs are also archived, e.g. sources,url")
+	screenshot         = flag.Bool("screenshot", false, "Save a full-page screenshot of each link, captured in headless Chrome, to screenshot.png in the link directory")
+	screenshotWidth    = flag.Int("screenshot-width", defaultScreenshotWidth, "Viewport width in pixels of the browser capturing screenshots")
+	sanitize           = flag.Bool("sanitize", false, "Remove scripts, event handlers, and other markup not needed to read an article from archived content, so that archives are safe to serve")
+	scanHTML           = flag.Bool("scan-html", false, "Also archive links from .html and .htm files in the input directory")
+	templateFile       = flag.String("template", "", "Path to a Go text/template producing archived files from .Metadata, .Frontmatter (YAML), and .Content")
+	bundleFormat       = flag.String("bundle", "", "Write archives into a single bundle in the output directory instead of loose files: zip or tar.gz")
+	keepHistory        = flag.Bool("keep-history", false, "Keep each capture of a link in a timestamped snapshot instead of overwriting the archive")
+	sitemapBaseURL     = flag.String("sitemap-base-url", "", "Write a sitemap.xml of the archives, with URLs relative to this base URL")
+	dedupeContent      = flag.Bool("dedupe-content", false, "Write a pointer to the existing archive instead of a second copy when a link's content matches another archive")
+	showVersion        = flag.Bool("version", false, "Print version information and exit")
+	printConfigOnly    = flag.Bool("print-config", false, "Print the settings a run would use, including the headers and domain rules files, as YAML with secrets redacted, and exit")
+	proxy              = flag.String("proxy", "", "HTTP or SOCKS5 proxy URL for outbound requests. Defaults to the HTTP_PROXY and HTTPS_PROXY environment variables")
 )
 
 // Metadata holds metadata about an archived resource.
 type Metadata struct {
-	URL        string    `yaml:"url"`
-	Title      string    `yaml:"title"`
-	ArchivedAt time.Time `yaml:"archived_at"`
+	URL           string     `yaml:"url"`
+	Title         string     `yaml:"title"`
+	SiteName      string     `yaml:"site_name,omitempty"`
+	Author        string     `yaml:"author,omitempty"`
+	Excerpt       string     `yaml:"excerpt,omitempty"`
+	Image         string     `yaml:"image,omitempty"`
+	PublishedAt   *time.Time `yaml:"published_at,omitempty"`
+	ArchivedAt    time.Time  `yaml:"archived_at"`
+	ContentHash   string     `yaml:"content_hash,omitempty"`
+	CaptureMethod string     `yaml:"capture_method,omitempty"`
+	Tags          []string   `yaml:"tags,omitempty"`
+	SourceFile    string     `yaml:"source_file,omitempty"`
+	// StatusCode and ContentLength describe the HTTP response the page was
+	// captured from, when it was fetched directly.
+	StatusCode    int   `yaml:"status_code,omitempty"`
+	ContentLength int64 `yaml:"content_length,omitempty"`
+	// ETag and LastModified are the validators of the response, which
+	// make refreshing the archive conditional on the page having changed.
+	ETag         string `yaml:"etag,omitempty"`
+	LastModified string `yaml:"last_modified,omitempty"`
+	// DuplicateOf is the ID of the archive holding the same content, when
+	// this archive is only a pointer to it.
+	DuplicateOf string `yaml:"duplicate_of,omitempty"`
+	// RequestedURL is the link the archive was requested for, when the
+	// page was archived under its canonical URL instead.
+	RequestedURL string `yaml:"requested_url,omitempty"`
+	// ScreenshotWidth and ScreenshotHeight are the dimensions in pixels of
+	// the screenshot in the link directory, when one was captured.
+	ScreenshotWidth  int `yaml:"screenshot_width,omitempty"`
+	ScreenshotHeight int `yaml:"screenshot_height,omitempty"`
 }
 
 type Archiver struct {
-	InputDir  string
+	// InputDir is the directory of markdown files to archive links from,
+	// or stdinInput to read markdown from stdin.
+	InputDir string
+	// InputGlob, if set, restricts the input to the files matching it, in
+	// the syntax of filepath.Match, instead of every file in InputDir.
+	// InputDir should be the directory the pattern starts at, as returned by
+	// globBase, since input files are still tracked relative to it.
+	InputGlob string
 	OutputDir string
+	// Refresh re-fetches links that have already been archived. Archives
+	// whose content has not changed are left untouched.
+	Refresh bool
+	// MaxIDLength is the maximum length of a link ID, excluding the
+	// appended hash. Defaults to defaultMaxIDLength.
+	MaxIDLength int
+	// HashLength is the number of hex characters of the link hash appended
+	// to link IDs. Defaults to defaultHashLength. IDs whose archive belongs
+	// to a different URL are extended with more of the hash until they are
+	// unique.
+	HashLength int
+	// LinkIDFunc, if set, replaces the default link ID scheme. It is called
+	// with the (normalized) link and must return an ID that is safe to use
+	// as a file name and unique per link, since links with the same ID
+	// share an archive. MaxIDLength is not applied to its IDs.
+	LinkIDFunc func(url string) (string, error)
+	// Normalize rewrites links into a canonical form before computing
+	// their IDs, so that variants of a link share an archive. Links are
+	// still fetched and recorded as written.
+	Normalize bool
+	// StripParams are the query parameters removed by normalization.
+	// Defaults to defaultStripParams.
+	StripParams []string
+	// Headers are additional HTTP headers sent when fetching links,
+	// matched by domain.
+	Headers HostHeaders
+	// DomainRules override the timeout, user agent, headers and rendering
+	// of links, matched by domain.
+	DomainRules DomainRules
+	// Verbose prints each outbound request. Header values are never
+	// printed since they may contain credentials.
+	Verbose bool
+	// Proxy is the proxy used for outbound requests. When nil, the proxy
+	// is taken from the HTTP_PROXY and HTTPS_PROXY environment variables.
+	Proxy *url.URL
+	// HTTPClient, if set, is used for all outbound requests in place of
+	// the default client. Proxy, MaxConnsPerHost and AllowPrivateIPs only
+	// configure the default client, so they have no effect on it.
+	HTTPClient *http.Client
+	// Fetcher, if set, fetches links and applies readability to them in
+	// place of the default fetch with HTTPClient. The status code and
+	// length of the response are only recorded by the default fetch.
+	Fetcher Fetcher
+	// Extractor extracts the content of the pages fetched or rendered by
+	// the archiver, when Fetcher isn't set. Defaults to readability.
+	Extractor Extractor
+	// Format is the output format of archived content. Defaults to
+	// formatHTML.
+	Format string
+	// SaveFavicon saves the favicon of each archived page to the link
+	// directory. Not supported for single-file archives.
+	SaveFavicon bool
+	// TagHeadings tags each archive with the headings the link appears
+	// under in the source markdown file.
+	TagHeadings bool
+	// Incremental skips markdown files that haven't been modified since
+	// they were last processed.
+	Incremental bool
+	// Force processes all markdown files, even when running incrementally.
+	Force bool
+	// UseCanonical archives pages under the canonical URL they declare
+	// with <link rel="canonical">, when it is on the same site, so that
+	// variants such as AMP pages and links with tracking parameters share
+	// an archive. The link is recorded as the archive's RequestedURL.
+	// Canonical URLs are only known for pages fetched by the default fetch.
+	UseCanonical bool
+	// SharedCaches are checked link caches of other output directories,
+	// given as cache files or the directories holding them. Links checked
+	// in any of them are skipped, as if they were in this output
+	// directory's cache, but the shared caches are never written. Link IDs
+	// are only shared between output directories using the same link ID
+	// scheme.
+	SharedCaches []string
+	// MaxPageBytes, if positive, is the maximum size in bytes of an
+	// archived file, including any inlined images. Larger captures are not
+	// archived, and their links are listed in tooLargeLinksFile in the
+	// output directory.
+	MaxPageBytes int64
+	// SkipContentTypes are patterns of the Content-Type of responses whose
+	// links are skipped rather than archived, such as application/json or
+	// application/*. Skipped links are checked, but not counted as failed.
+	SkipContentTypes []string
+	// LogFormat is the format of logged events, either logFormatText or
+	// logFormatJSON. Defaults to logFormatText.
+	LogFormat string
+	// TrustFilesystem ignores the checked link cache, and skips links
+	// based only on whether an archive exists in the output directory, so
+	// links that failed before are retried. This suits output directories
+	// whose cache is lost or was merged from elsewhere.
+	TrustFilesystem bool
+	// WaitLock waits for another run holding the lock on the output
+	// directory to finish, instead of failing with ErrLocked.
+	WaitLock bool
+	// DebugLogs appends a line recording each fetch of a link, with its
+	// timing, final URL, status and capture method, to an archive.log
+	// file in the link directory.
+	DebugLogs bool
+	// Since, if set, skips input files last modified before it. Links read
+	// from stdin are always processed.
+	Since time.Time
+	// GitDiffBase, if set, is a git ref, and only the links on lines added
+	// to the input directory since it, including uncommitted changes to
+	// tracked files, are archived. Every input file is processed when the input directory
+	// isn't in a git repository. Links read from stdin are always processed.
+	GitDiffBase string
+	// MinContentLength is the minimum length in bytes of captured content.
+	// Shorter captures are treated as failures and are not cached, so that
+	// they are retried on the next run. Empty captures are always rejected.
+	MinContentLength int
+	// RenderJS renders pages in a headless browser when a plain fetch fails
+	// or captures less than MinContentLength bytes of content.
+	RenderJS bool
+	// DedupeContent writes a pointer to the existing archive, instead of a
+	// second copy, when a link's content exactly matches an archive of
+	// another link.
+	DedupeContent bool
+	// SitemapBaseURL, if set, writes a sitemap of the archives to the
+	// output directory at the end of each run, with URLs relative to it.
+	SitemapBaseURL string
+	// KeepHistory keeps every capture of a link in a timestamped snapshot
+	// directory, with a latest symlink pointing to the most recent one,
+	// instead of overwriting the archive. Not supported for single-file
+	// archives.
+	KeepHistory bool
+	// Bundle, if set, writes archives into a single bundleZip or
+	// bundleTarGz file in the output directory instead of as loose files.
+	// Not supported with KeepHistory or SitemapBaseURL, and favicons are not
+	// saved.
+	Bundle string
+	// Template, if set, produces the contents of archived files from the
+	// archive's metadata and rendered content, in place of the default YAML
+	// frontmatter. Archives without YAML frontmatter can't be read back, so
+	// they are always rewritten on refresh, and are not pruned or listed in
+	// the sitemap.
+	Template *template.Template
+	// Sanitize removes scripts, event handler attributes and other markup
+	// that isn't needed to read an article from captured content, so that
+	// archives are safe to serve. Off by default to keep the content as
+	// captured.
+	Sanitize bool
+	// Screenshot saves a full-page screenshot of each link, captured in a
+	// headless browser independently of the archived content, to the link
+	// directory. Not supported for single-file archives or bundles.
+	Screenshot bool
+	// ScreenshotWidth is the viewport width in pixels of the browser
+	// capturing screenshots. Defaults to defaultScreenshotWidth.
+	ScreenshotWidth int
+	// ScanHTML also archives links from HTML files in the input directory.
+	ScanHTML bool
+	// FrontmatterFields are the top-level fields of the YAML frontmatter of
+	// markdown files whose URLs are archived, along with the links in the
+	// body. A field may hold a URL or a list of URLs.
+	FrontmatterFields []string
+	// AllowLocal archives links to files, the local machine, private
+	// networks, and the output directory, which are skipped by default.
+	AllowLocal bool
+	// AllowPrivateIPs allows requests to hosts that resolve to private,
+	// loopback and link-local addresses, which are refused by default.
+	AllowPrivateIPs bool
+	// MaxConnsPerHost limits the connections, including idle connections
+	// kept for reuse, to each host. Defaults to defaultMaxConnsPerHost.
+	MaxConnsPerHost int
+	// MetricsFile, if set, is written with the metrics of each run in the
+	// Prometheus text exposition format.
+	MetricsFile string
+	// MaxLinks, if positive, stops a run with ErrMaxLinksReached before it
+	// fetches more than MaxLinks links, as a safeguard against input that
+	// unexpectedly contains many links. The progress made until then is
+	// kept, as when the run's context is done. When watching, the limit
+	// applies to each batch of changed files.
+	MaxLinks int
+	// FailOnError returns an ErrLinksFailed error from a run that
+	// completes, but couldn't archive some links, instead of nil.
+	FailOnError bool
+	// CheckpointArchives, if positive, writes the caches after every
+	// CheckpointArchives links archived during a run, rather than only at
+	// the end, so that a run that is killed keeps its progress.
+	CheckpointArchives int
+	// CheckpointInterval, if positive, writes the caches at least this
+	// often during a run.
+	CheckpointInterval time.Duration
+
+	// OnArchived, if set, is called after each link is successfully
+	// archived, with the archive's metadata and the path of the archived
+	// file.
+	OnArchived func(metadata Metadata, contentPath string)
+	// OnError, if set, is called when a link cannot be archived.
+	OnError func(url string, err error)
+
+	checkedLinks   map[string]bool
+	lastChecked    map[string]time.Time
+	processedLinks map[string]bool
+	// linkIDURLs maps the link IDs given out during a run to their links, so
+	// that colliding links are told apart before either is archived.
+	linkIDURLs     map[string]string
+	processedFiles map[string]time.Time
+	// sharedCheckedLinks are the links checked according to SharedCaches.
+	sharedCheckedLinks map[string]bool
+	// tooLargeLinks are the links whose captures exceeded MaxPageBytes.
+	tooLargeLinks map[string]bool
+	// gitAddedLines are the lines added to each input file since
+	// GitDiffBase, or nil to process every input file.
+	gitAddedLines map[string]map[int]bool
+	contentHashes map[string]string
+	// ignoreCache maps directories to the patterns of the ignore files that
+	// apply to them.
+	ignoreCache map[string][]ignorePattern
+	// bundle is the bundle being written during a run, when bundling.
+	bundle *bundle
+	// lock is the lock held on the output directory during a run.
+	lock *outputLock
+
+	// newLinks are the number of links fetched during a run, towards
+	// MaxLinks.
+	newLinks int
+	// uncheckpointedArchives are the number of links archived since the
+	// caches were last written, at lastCheckpoint.
+	uncheckpointedArchives int
+	lastCheckpoint         time.Time
+	// serveMu serializes the requests of the HTTP API, which share the
+	// state of a run, and servingSince is when the API started.
+	serveMu      sync.Mutex
+	servingSince time.Time
+
+	hookMu  sync.Mutex
+	metrics runMetrics
 
-	checkedLinks map[string]bool
+	clientOnce sync.Once
+	client     *http.Client
+
+	// browser is the headless browser shared by the links of a run, and
+	// cancelBrowser closes it.
+	browserMu     sync.Mutex
+	browser       context.Context
+	cancelBrowser context.CancelFunc
+
+	// lookupIP resolves the addresses of a host when checking for private
+	// addresses. Defaults to net.DefaultResolver.LookupIPAddr.
+	lookupIP func(ctx context.Context, host string) ([]net.IPAddr, error)
+	// renderPage renders a link in a headless browser and returns the
+	// resulting HTML. Defaults to Archiver.renderPageWithChrome.
+	renderPage func(link string) (string, error)
+	// screenshotPage captures a PNG screenshot of a link in a headless
+	// browser. Defaults to Archiver.screenshotPageWithChrome.
+	screenshotPage func(link string, width int) ([]byte, error)
+	// stdin is read when InputDir is stdinInput. Defaults to os.Stdin.
+	stdin io.Reader
+	// stdout and stderr are written with logged events. Default to
+	// os.Stdout and os.Stderr.
+	stdout io.Writer
+	stderr io.Writer
 }
 
-func (a *Archiver) processLinksInMarkdownFile(filePath string) error {
-	f, err := os.Open(filePath)
+func (a *Archiver) processLinksInFile(ctx context.Context, filePath string) error {
+	links, err := a.readLinksInFile(filePath)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	b, err := io.ReadAll(f)
+	links, err = a.filterIgnored(filePath, links)
 	if err != nil {
 		return err
 	}
+	links = a.filterGitDiff(filePath, links)
+	return a.processLinks(ctx, a.relativeInputPath(filePath), links)
+}
 
-	links, err := parseLinksFromMarkdown(string(b))
+// processLinksInMarkdown archives the links in markdown read from r. source
+// identifies where the markdown came from, and is recorded in the metadata of
+// each archive.
+func (a *Archiver) processLinksInMarkdown(ctx context.Context, source string, r io.Reader) error {
+	b, err := io.ReadAll(r)
 	if err != nil {
 		return err
 	}
-	if len(links) > 0 {
-		for _, link := range links {
-			linkID, err := getLinkID(link)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "cannot get link ID: %v", err)
-			}
+	return a.processLinks(ctx, source, parseLinksFromMarkdownWithPositions(string(b)))
+}
 
-			if a.isLinkCheckedBefore(linkID) {
-				continue
-			}
+// processLinks archives links, which were found in source.
+func (a *Archiver) processLinks(ctx context.Context, source string, links []markdownLink) error {
+	for _, l := range dedupeLinks(links) {
+		if _, err := a.archiveLink(ctx, source, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			// check if link has been archived before
-			linkIDFilePath := path.Join(*outputDir, linkID)
-			_, err = os.Stat(linkIDFilePath)
-			if !os.IsNotExist(err) {
-				// cache file is out of sync with directory structure, update cache
-				a.setLinkChecked(linkID)
-				continue
-			}
+// linkResult is the outcome of archiving a single link.
+type linkResult struct {
+	// Event is eventArchived, eventSkipped or eventFailed.
+	Event  string
+	LinkID string
+	// Metadata is the metadata of the archive that was written, when the
+	// link was archived.
+	Metadata Metadata
+	// Err is why the link couldn't be archived, when it failed.
+	Err error
+}
 
-			// apply readability
-			article, err := readability.FromURL(link, 5*time.Second)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "cannot apply readability for %+v: %+v\n", link, err)
-				a.setLinkChecked(linkID)
-				continue
-			}
+// archiveLink archives a single link found in source. Links that can't be
+// archived are logged and reported in the result, and an error is only
+// returned when the run should stop.
+func (a *Archiver) archiveLink(ctx context.Context, source string, l markdownLink) (linkResult, error) {
+	event := logEvent{URL: l.URL, SourceFile: source, Line: l.Line}
+	if err := ctx.Err(); err != nil {
+		a.logf(event.as(eventStopped, err), "stopped before %+v (%s:%d): %+v", l.URL, source, l.Line, err)
+		return linkResult{}, err
+	}
+	if err := a.checkpoint(); err != nil {
+		return linkResult{}, err
+	}
 
-			// construct archived file contents
-			metadata := Metadata{
-				URL:        link,
-				Title:      article.Title,
-				ArchivedAt: time.Now(),
-			}
-			b, err := yaml.Marshal(metadata)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "cannot marshal yaml frontmatter for %+v: %+v\n", link, err)
-				continue
-			}
-			content := fmt.Sprintf("---\n%s\n---\n%s", strings.Trim(string(b), "\n"), article.Content)
+	// the link is fetched and recorded as written, since normalizing it
+	// can change what the server returns, and only its ID is computed
+	// from the normalized form
+	link := l.URL
+	normalized, err := a.normalizeLink(link)
+	if err != nil {
+		a.logf(event.as(eventWarning, err), "cannot normalize link %+v (%s:%d): %+v", link, source, l.Line, err)
+	}
 
-			// write content to file
-			err = os.Mkdir(linkIDFilePath, 0755)
-			if err != nil {
-				return err
-			}
-			archivedFile, err := os.Create(path.Join(linkIDFilePath, "index.html"))
-			if err != nil {
-				return err
-			}
-			archivedFile.WriteString(content)
-			archivedFile.Close()
+	if !a.AllowLocal && a.isLocalLink(link) {
+		a.logf(event.as(eventSkipped, nil), "skipping local link %+v (%s:%d)", link, source, l.Line)
+		return linkResult{Event: eventSkipped}, nil
+	}
+
+	linkID, err := a.linkID(normalized)
+	if err != nil {
+		a.logf(event.as(eventFailed, err), "cannot get link ID for %+v (%s:%d): %v", link, source, l.Line, err)
+		a.notifyError(link, err)
+		return linkResult{Event: eventFailed, Err: err}, nil
+	}
+	event.LinkID = linkID
 
-			fmt.Printf("Archived %s\n", link)
+	// only process each link once per run, even if it appears in
+	// multiple files
+	if a.processedLinks[linkID] {
+		return linkResult{Event: eventSkipped, LinkID: linkID}, nil
+	}
+	a.processedLinks[linkID] = true
+	if !a.Refresh && a.isLinkCheckedBefore(linkID) {
+		a.logf(event.as(eventSkipped, nil), "")
+		return linkResult{Event: eventSkipped, LinkID: linkID}, nil
+	}
+
+	// check if link has been archived before
+	archivedFilePath := a.currentArchivePath(linkID)
+	archivedBefore := a.isArchived(archivedFilePath)
+	if (archivedBefore || a.TrustFilesystem && a.hasLinkArchive(linkID)) && !a.Refresh {
+		// cache file is out of sync with directory structure, update cache
+		a.setLinkChecked(linkID)
+		a.logf(event.as(eventSkipped, nil), "")
+		return linkResult{Event: eventSkipped, LinkID: linkID}, nil
+	}
+
+	if a.MaxLinks > 0 && a.newLinks >= a.MaxLinks {
+		a.logf(event.as(eventStopped, ErrMaxLinksReached), "stopped before %+v (%s:%d): %+v", link, source, l.Line, ErrMaxLinksReached)
+		return linkResult{}, ErrMaxLinksReached
+	}
+	a.newLinks++
+
+	// when refreshing, only fetch the page again if it has changed
+	// since it was archived
+	var validators cacheValidators
+	if archivedBefore {
+		if existing, err := readMetadata(archivedFilePath); err == nil {
+			validators = cacheValidators{ETag: existing.ETag, LastModified: existing.LastModified}
+		}
+	}
+
+	// apply readability, falling back to rendering the page if
+	// scripts are needed to produce its content
+	fetchStart := time.Now()
+	article, err := a.fetch(ctx, link, validators)
+	a.metrics.addFetch(time.Since(fetchStart))
+	captureMethod := captureMethodFetch
+	if a.renderJS(link) && !article.skipsContent() && (err != nil || a.isContentTooShort(article.Content)) {
+		event.Duration = time.Since(fetchStart)
+		a.logf(event.as(eventRetried, err), "")
+		rendered, renderErr := a.renderArticle(ctx, link)
+		if renderErr != nil {
+			a.logf(event.as(eventWarning, renderErr), "cannot render %+v (%s:%d): %+v", link, source, l.Line, renderErr)
+		} else {
+			article, err = fetchedPage{Article: rendered}, nil
+			captureMethod = captureMethodRenderJS
+		}
+	}
+	attempt := fetchLog{
+		StartedAt:     fetchStart,
+		Duration:      time.Since(fetchStart),
+		CaptureMethod: captureMethod,
+		URL:           article.FinalURL,
+		StatusCode:    article.StatusCode,
+		Err:           err,
+	}
+	// links archived for the first time are logged once their
+	// link directory has been written
+	logged := a.logFetch(linkID, attempt)
+	event.Duration = attempt.Duration
+	if err != nil && ctx.Err() != nil {
+		// the run was stopped mid-fetch, so the link didn't
+		// fail and should be retried on the next run
+		a.logf(event.as(eventStopped, ctx.Err()), "stopped while archiving %+v (%s:%d): %+v", link, source, l.Line, ctx.Err())
+		return linkResult{}, ctx.Err()
+	}
+	if err != nil {
+		// the screenshot doesn't depend on readability, so it is
+		// still useful when the page can't be archived
+		a.captureScreenshot(ctx, link, linkID, event)
+		a.logf(event.as(eventFailed, err), "cannot apply readability for %+v (%s:%d): %+v", link, source, l.Line, err)
+		a.notifyError(link, err)
+		a.setLinkChecked(linkID)
+		return linkResult{Event: eventFailed, Err: err}, nil
+	}
+	if article.NotModified {
+		a.setLastChecked(linkID, time.Now())
+		a.setLinkChecked(linkID)
+		a.logf(event.as(eventSkipped, nil), "")
+		return linkResult{Event: eventSkipped, LinkID: linkID}, nil
+	}
+	if article.SkippedContentType != "" {
+		a.setLinkChecked(linkID)
+		a.logf(event.as(eventSkipped, nil), "skipping %+v (%s:%d) with content type %s", link, source, l.Line, article.SkippedContentType)
+		return linkResult{Event: eventSkipped, LinkID: linkID}, nil
+	}
+
+	// archive the page under its canonical URL, so that variants
+	// of the same page share an archive
+	requestedID := linkID
+	var requestedURL string
+	canonical, ok := a.canonicalLink(link, article.CanonicalURL)
+	if a.UseCanonical && ok {
+		normalizedCanonical, _ := a.normalizeLink(canonical)
+		canonicalID, err := a.linkID(normalizedCanonical)
+		if err != nil {
+			a.logf(event.as(eventFailed, err), "cannot get link ID for %+v (%s:%d): %v", canonical, source, l.Line, err)
+			a.notifyError(link, err)
+			return linkResult{Event: eventFailed, Err: err}, nil
+		}
+		if a.processedLinks[canonicalID] {
+			a.setLinkChecked(requestedID)
+			return linkResult{Event: eventSkipped, LinkID: canonicalID}, nil
+		}
+		a.processedLinks[canonicalID] = true
+		requestedURL = link
+		link, linkID = canonical, canonicalID
+		event.URL, event.LinkID = link, linkID
+		archivedFilePath = a.currentArchivePath(linkID)
+		archivedBefore = a.isArchived(archivedFilePath)
+		if archivedBefore && !a.Refresh {
+			a.setLinkChecked(linkID)
+			a.setLinkChecked(requestedID)
+			a.logf(event.as(eventSkipped, nil), "")
+			return linkResult{Event: eventSkipped, LinkID: linkID}, nil
+		}
+	}
+
+	if a.Sanitize {
+		article.Content = sanitizeContent(article.Content)
+	}
+	if a.isContentTooShort(article.Content) {
+		err := fmt.Errorf("captured content is shorter than %d bytes", a.minContentLength())
+		a.logf(event.as(eventFailed, err), "cannot archive %+v (%s:%d): %+v", link, source, l.Line, err)
+		a.notifyError(link, err)
+		return linkResult{Event: eventFailed, Err: err}, nil
+	}
+
+	// skip the rewrite if the content is unchanged since the
+	// previous archive
+	contentHash := hashContent(article.Content)
+	if archivedBefore {
+		existing, err := readMetadata(archivedFilePath)
+		if err == nil && existing.ContentHash == contentHash {
+			a.setLastChecked(linkID, time.Now())
 			a.setLinkChecked(linkID)
+			a.setLinkChecked(requestedID)
+			a.logf(event.as(eventSkipped, nil), "")
+			return linkResult{Event: eventSkipped, LinkID: linkID}, nil
+		}
+	}
+
+	// construct archived file contents
+	metadata := Metadata{
+		URL:           link,
+		Title:         article.Title,
+		SiteName:      article.SiteName,
+		Author:        article.Byline,
+		Excerpt:       article.Excerpt,
+		Image:         article.Image,
+		PublishedAt:   article.PublishedTime,
+		ArchivedAt:    time.Now(),
+		ContentHash:   contentHash,
+		CaptureMethod: captureMethod,
+		SourceFile:    source,
+		StatusCode:    article.StatusCode,
+		ContentLength: article.ContentLength,
+		ETag:          article.Validators.ETag,
+		LastModified:  article.Validators.LastModified,
+		RequestedURL:  requestedURL,
+	}
+	// the text of the link describes the page when readability
+	// can't find its title
+	if metadata.Title == "" {
+		metadata.Title = l.Text
+	}
+	if a.TagHeadings {
+		metadata.Tags = l.Headings
+	}
+	if canonicalID, ok := a.duplicateOf(contentHash, linkID); ok {
+		metadata.DuplicateOf = canonicalID
+	}
+	// when keeping history, each capture is written to a new
+	// snapshot rather than overwriting the current archive
+	filePath := archivedFilePath
+	if a.KeepHistory {
+		filePath = a.snapshotPath(linkID, metadata.ArchivedAt)
+	}
+	var body string
+	if metadata.DuplicateOf != "" {
+		body, err = a.duplicateBody(filePath, metadata.DuplicateOf)
+	} else {
+		body, err = a.renderContent(article.Content)
+	}
+	if err != nil {
+		a.logf(event.as(eventFailed, err), "cannot render content for %+v: %+v", link, err)
+		a.notifyError(link, err)
+		return linkResult{Event: eventFailed, Err: err}, nil
+	}
+	content, err := a.archiveContent(metadata, body)
+	if err != nil {
+		a.logf(event.as(eventFailed, err), "cannot format archive for %+v: %+v", link, err)
+		a.notifyError(link, err)
+		return linkResult{Event: eventFailed, Err: err}, nil
+	}
+	// oversized captures are left out entirely rather than
+	// archived in part, and aren't retried unless refreshing
+	if err := a.checkPageSize(content); err != nil {
+		a.logf(event.as(eventFailed, err), "cannot archive %+v (%s:%d): %+v", link, source, l.Line, err)
+		a.notifyError(link, err)
+		a.setTooLarge(link, true)
+		a.setLinkChecked(linkID)
+		return linkResult{Event: eventFailed, Err: err}, nil
+	}
+
+	// the screenshot is only captured once the page is going to be
+	// archived, so that pages that are rejected or unchanged don't
+	// leave one behind
+	if width, height := a.captureScreenshot(ctx, link, linkID, event); width > 0 {
+		metadata.ScreenshotWidth, metadata.ScreenshotHeight = width, height
+		content, err = a.archiveContent(metadata, body)
+		if err != nil {
+			a.logf(event.as(eventFailed, err), "cannot format archive for %+v: %+v", link, err)
+			a.notifyError(link, err)
+			return linkResult{Event: eventFailed, Err: err}, nil
+		}
+	}
+
+	// write content to file
+	if a.bundle != nil {
+		err = a.addToBundle(filePath, metadata.ArchivedAt, content)
+	} else if a.KeepHistory {
+		err = a.writeSnapshot(linkID, filePath, strings.NewReader(content))
+	} else {
+		err = writeArchive(a.OutputDir, filePath, strings.NewReader(content))
+	}
+	if err != nil {
+		a.logf(event.as(eventFailed, err), "")
+		a.notifyError(link, err)
+		return linkResult{}, err
+	}
+	if !logged {
+		a.logFetch(linkID, attempt)
+	}
+
+	if a.SaveFavicon && a.Format != formatSingleFile && metadata.DuplicateOf == "" && a.bundle == nil {
+		// a missing favicon shouldn't fail the archive
+		err = a.saveFavicon(link, article.Favicon, path.Dir(filePath))
+		if err != nil && a.Verbose {
+			a.logf(event.as(eventWarning, err), "cannot save favicon for %+v: %+v", link, err)
 		}
 	}
+
+	if metadata.DuplicateOf != "" {
+		a.logf(event.as(eventArchived, nil), "Archived %s (duplicate of %s)", link, metadata.DuplicateOf)
+	} else {
+		a.logf(event.as(eventArchived, nil), "Archived %s", link)
+		a.setContentHash(contentHash, linkID)
+	}
+	a.setTooLarge(link, false)
+	a.setLastChecked(linkID, metadata.ArchivedAt)
+	a.setLinkChecked(linkID)
+	a.setLinkChecked(requestedID)
+	a.notifyArchived(metadata, filePath)
+	return linkResult{Event: eventArchived, LinkID: linkID, Metadata: metadata}, nil
+}
+
+// normalizeLink normalizes link if normalization is enabled. The original link
+// is returned if it cannot be normalized.
+func (a *Archiver) normalizeLink(link string) (string, error) {
+	if !a.Normalize {
+		return link, nil
+	}
+	normalized, err := normalizeURL(link, a.stripParams())
+	if err != nil {
+		return link, err
+	}
+	return normalized, nil
+}
+
+func (a *Archiver) minContentLength() int {
+	if a.MinContentLength > 1 {
+		return a.MinContentLength
+	}
+	return 1
+}
+
+// isContentTooShort reports whether captured content is too short to be worth
+// archiving.
+func (a *Archiver) isContentTooShort(content string) bool {
+	return len(strings.TrimSpace(content)) < a.minContentLength()
+}
+
+func (a *Archiver) maxIDLength() int {
+	if a.MaxIDLength > 0 {
+		return a.MaxIDLength
+	}
+	return defaultMaxIDLength
+}
+
+func (a *Archiver) hashLength() int {
+	if a.HashLength > 0 && a.HashLength <= maxHashLength {
+		return a.HashLength
+	}
+	return defaultHashLength
+}
+
+// linkID returns the ID of link, using LinkIDFunc if it is set.
+func (a *Archiver) linkID(link string) (string, error) {
+	if a.LinkIDFunc == nil {
+		return a.uniqueLinkID(link)
+	}
+	linkID, err := a.LinkIDFunc(link)
+	if err != nil {
+		return "", err
+	}
+	// IDs become paths in the output directory, so reject anything that
+	// could escape it or be mistaken for one of the cache files
+	if linkID == "" || strings.HasPrefix(linkID, ".") || strings.ContainsAny(linkID, `/\`) {
+		return "", fmt.Errorf("%w %q", ErrInvalidLinkID, linkID)
+	}
+	return linkID, nil
+}
+
+func (a *Archiver) stripParams() []string {
+	if a.StripParams != nil {
+		return a.StripParams
+	}
+	return defaultStripParams
+}
+
+// hashContent returns the hex-encoded SHA-256 hash of an article's content.
+func hashContent(content string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+}
+
+// readMetadata reads the YAML frontmatter of an archived file.
+func readMetadata(filePath string) (Metadata, error) {
+	var metadata Metadata
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return metadata, err
+	}
+	// single-file archives embed the frontmatter in an HTML comment
+	content := strings.TrimPrefix(string(b), "<!--\n")
+	if !strings.HasPrefix(content, "---\n") {
+		return metadata, errors.New("missing frontmatter")
+	}
+	end := strings.Index(content[len("---\n"):], "\n---\n")
+	if end < 0 {
+		return metadata, errors.New("unterminated frontmatter")
+	}
+	err = yaml.Unmarshal([]byte(content[len("---\n"):len("---\n")+end]), &metadata)
+	return metadata, err
+}
+
+// writeArchive writes archived content to filePath, which is either directly
+// within outputDir or within a link directory in outputDir. The content is
+// first written to a temporary file in outputDir and only renamed into place,
+// creating the link directory if needed, once the write has fully succeeded,
+// so an interrupted write never leaves a partial file behind.
+func writeArchive(outputDir, filePath string, r io.Reader) error {
+	openFiles <- struct{}{}
+	defer func() { <-openFiles }()
+
+	tmpFile, err := createTempWithRetry(outputDir, ".archive-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	// no-op once the file has been renamed into place
+	defer os.Remove(tmpPath)
+
+	if _, err := io.Copy(tmpFile, r); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Chmod(0644); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	linkDir := path.Dir(filePath)
+	createdDir := false
+	if path.Clean(linkDir) != path.Clean(outputDir) {
+		err = os.Mkdir(linkDir, 0755)
+		if err == nil {
+			createdDir = true
+		} else if !os.IsExist(err) {
+			return err
+		}
+	}
+	err = os.Rename(tmpPath, filePath)
+	if err != nil {
+		if createdDir {
+			os.Remove(linkDir)
+		}
+		return err
+	}
 	return nil
 }
 
-func getLinkID(link string) (string, error) {
+// uniqueLinkID returns the default ID of link. If the ID was already given to
+// a different link during this run, or its archive belongs to a different URL,
+// the appended hash is extended until the ID is unique.
+func (a *Archiver) uniqueLinkID(link string) (string, error) {
+	if a.linkIDURLs == nil {
+		a.linkIDURLs = make(map[string]string)
+	}
+	for n := a.hashLength(); ; n++ {
+		linkID, err := getLinkID(link, a.maxIDLength(), n)
+		if err != nil {
+			return "", err
+		}
+		if n < maxHashLength && a.isLinkIDTaken(linkID, link) {
+			continue
+		}
+		a.linkIDURLs[linkID] = link
+		return linkID, nil
+	}
+}
+
+// isLinkIDTaken reports whether linkID belongs to a link other than link.
+func (a *Archiver) isLinkIDTaken(linkID, link string) bool {
+	if owner, ok := a.linkIDURLs[linkID]; ok {
+		return owner != link
+	}
+	// archives in a bundle aren't read back, so only archives in the output
+	// directory are checked
+	if a.bundle != nil || a.OutputDir == "" {
+		return false
+	}
+	metadata, err := readMetadata(a.currentArchivePath(linkID))
+	if err != nil || metadata.URL == "" {
+		return false
+	}
+	// archives record links as written, while IDs are computed from
+	// normalized links
+	for _, archived := range []string{metadata.URL, metadata.RequestedURL} {
+		if archived == "" {
+			continue
+		}
+		if normalized, _ := a.normalizeLink(archived); normalized == link {
+			return false
+		}
+	}
+	return true
+}
+
+// getLinkID returns a filesystem-safe ID for link. The readable portion of the
+// ID is truncated to maxLength runes before the first hashLength hex characters
+// of a hash of the link are appended.
+func getLinkID(link string, maxLength, hashLength int) (string, error) {
 	u, err := url.Parse(link)
 	if err != nil {
 		return "", err
@@ -137,63 +974,248 @@ func getLinkID(link string) (string, error) {
 
 	// processing:
 	// 1. replace / with _
-	// 2. replace ?= with -
+	// 2. replace ?=& with -
 	// 3. remove any character not in our allowed set
 	linkID = strings.ReplaceAll(linkID, "/", "_")
 	linkID = strings.ReplaceAll(linkID, "?", "-")
 	linkID = strings.ReplaceAll(linkID, "=", "-")
+	linkID = strings.ReplaceAll(linkID, "&", "-")
 	r := regexp.MustCompile("[^a-zA-Z0-9_?=.-]+")
 	linkID = r.ReplaceAllString(linkID, "")
 	linkID = strings.TrimRight(linkID, "_")
 
-	// truncate link ID
+	// truncate link ID, making sure the cut doesn't leave a dangling
+	// separator before the hash
 	runes := []rune(linkID)
-	if len(runes) > 100 {
-		linkID = string(runes[:100])
+	if len(runes) > maxLength {
+		linkID = string(runes[:maxLength])
+		linkID = strings.TrimRight(linkID, "_-.")
 	}
 
-	// append a hash for uniqueness
+	// append a hash of the original link for uniqueness, since different
+	// links can be processed into the same ID
 	hash := sha256.Sum256([]byte(link))
-	truncatedHash := fmt.Sprintf("%x", hash)[:8]
+	truncatedHash := fmt.Sprintf("%x", hash)[:hashLength]
+	if linkID == "" {
+		return truncatedHash, nil
+	}
 	linkID = linkID + "_" + truncatedHash
 
 	return linkID, nil
 }
 
-func parseLinksFromMarkdown(markdown string) (links []string, err error) {
-	matches := markdownLinkRegex.FindAllStringSubmatch(markdown, -1)
-	for _, match := range matches {
-		links = append(links, match[1])
+func (a *Archiver) Archive() error {
+	return a.ArchiveContext(context.Background())
+}
+
+// ArchiveContext is like Archive, but stops once ctx is done. The caches are
+// still written for the links archived before then, so that the next run
+// picks up where this one stopped, and ctx's error is returned.
+func (a *Archiver) ArchiveContext(ctx context.Context) error {
+	release, err := a.acquireLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer a.closeBrowser()
+	err = a.initCaches()
+	if err != nil {
+		return err
+	}
+	err = a.initGitDiff(ctx)
+	if err != nil {
+		return err
+	}
+	a.processedLinks = make(map[string]bool)
+	a.ignoreCache = nil
+	a.metrics = runMetrics{}
+	a.newLinks = 0
+	a.startCheckpoints()
+	err = a.openBundle()
+	if err != nil {
+		return err
 	}
-	return links, nil
+	if a.InputDir == stdinInput {
+		err = a.processLinksInMarkdown(ctx, stdinSource, a.stdinReader())
+	} else {
+		err = a.walkInputFiles(func(filePath string, info os.FileInfo) error {
+			if a.isFileBeforeSince(info) || a.isFileOutsideGitDiff(filePath) || a.isFileUnchanged(filePath, info) {
+				return nil
+			}
+			err := a.processLinksInFile(ctx, filePath)
+			if err != nil {
+				return err
+			}
+			a.setFileProcessed(filePath, info)
+			return nil
+		})
+	}
+	// stopErr is why the run stopped before processing every link, if it
+	// did, in which case the progress so far is still kept
+	var stopErr error
+	if err != nil && (err == ctx.Err() || err == ErrMaxLinksReached) {
+		stopErr = err
+	} else if err != nil {
+		a.closeBundle(false)
+		return err
+	}
+	err = a.closeBundle(true)
+	if err != nil {
+		return err
+	}
+	err = a.writeCaches()
+	if err != nil {
+		return err
+	}
+	if a.MetricsFile != "" {
+		err = a.writeMetrics(time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	if stopErr != nil {
+		return stopErr
+	}
+	if a.SitemapBaseURL != "" {
+		err = a.writeSitemap()
+		if err != nil {
+			return err
+		}
+	}
+	if failed := a.metrics.failureCount(); a.FailOnError && failed > 0 {
+		return fmt.Errorf("%w: %d failed", ErrLinksFailed, failed)
+	}
+	return nil
 }
 
-func (a *Archiver) Archive() error {
+// initCaches reads the caches in the output directory of the links and
+// files processed by previous runs.
+func (a *Archiver) initCaches() error {
 	err := a.initCheckedLinkCache()
 	if err != nil {
 		return err
 	}
-	err = filepath.Walk(a.InputDir,
+	err = a.initSharedCaches()
+	if err != nil {
+		return err
+	}
+	err = a.initLastCheckedCache()
+	if err != nil {
+		return err
+	}
+	err = a.initProcessedFileCache()
+	if err != nil {
+		return err
+	}
+	err = a.initContentHashes()
+	if err != nil {
+		return err
+	}
+	return a.initTooLargeLinks()
+}
+
+// writeCaches writes the caches of links and files processed so far.
+func (a *Archiver) writeCaches() error {
+	err := a.writeCheckedLinkCache()
+	if err != nil {
+		return err
+	}
+	err = a.writeLastCheckedCache()
+	if err != nil {
+		return err
+	}
+	err = a.writeTooLargeLinks()
+	if err != nil {
+		return err
+	}
+	return a.writeProcessedFileCache()
+}
+
+func (a *Archiver) stdinReader() io.Reader {
+	if a.stdin != nil {
+		return a.stdin
+	}
+	return os.Stdin
+}
+
+// walkInputFiles calls fn for each markdown file in the input directory, and
+// each HTML file if ScanHTML is set. When InputGlob is set, only the files
+// matching it are visited.
+func (a *Archiver) walkInputFiles(fn func(filePath string, info os.FileInfo) error) error {
+	if a.InputGlob != "" {
+		return a.walkInputGlob(fn)
+	}
+	return filepath.Walk(a.InputDir,
 		func(filePath string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
-			if strings.HasSuffix(filePath, ".md") || strings.HasSuffix(filePath, ".markdown") {
-				err := a.processLinksInMarkdownFile(filePath)
-				if err != nil {
-					return err
-				}
+			if !info.IsDir() && a.isInputFile(filePath) {
+				return fn(filePath, info)
 			}
 			return nil
 		})
+}
+
+// isInputFile reports whether links should be archived from filePath.
+func (a *Archiver) isInputFile(filePath string) bool {
+	if !a.isInputGlobMatch(filePath) {
+		return false
+	}
+	if strings.HasSuffix(filePath, ".md") || strings.HasSuffix(filePath, ".markdown") {
+		return true
+	}
+	return a.ScanHTML && isHTMLFile(filePath)
+}
+
+// readLinksInFile returns the links in filePath, parsing it as HTML or
+// markdown according to its extension. Markdown files of at least
+// streamingParseMinBytes are parsed a line at a time rather than read into
+// memory whole.
+func (a *Archiver) readLinksInFile(filePath string) ([]markdownLink, error) {
+	if isHTMLFile(filePath) {
+		b, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return parseLinksFromHTML(string(b)), nil
+	}
+	info, err := os.Stat(filePath)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if info.Size() < streamingParseMinBytes {
+		b, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		links := a.frontmatterLinks(filePath, string(b))
+		return append(links, parseLinksFromMarkdownWithPositions(string(b))...), nil
 	}
-	err = a.writeCheckedLinkCache()
+	f, err := os.Open(filePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	defer f.Close()
+	var links []markdownLink
+	if len(a.FrontmatterFields) > 0 {
+		// read the frontmatter from the start of the file, then stream
+		// the file from the start again
+		head := make([]byte, maxFrontmatterBytes)
+		n, err := io.ReadFull(f, head)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		links = a.frontmatterLinks(filePath, string(head[:n]))
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	bodyLinks, err := parseLinksFromMarkdownReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return append(links, bodyLinks...), nil
 }
 
 func (a *Archiver) setLinkChecked(linkID string) {
@@ -202,8 +1224,16 @@ func (a *Archiver) setLinkChecked(linkID string) {
 	}
 }
 
+// checkedLinksFile is the name of the checked link cache in an output
+// directory.
+const checkedLinksFile = ".checked_links.txt"
+
 func (a *Archiver) writeCheckedLinkCache() error {
-	cacheFile, err := os.OpenFile(path.Join(*outputDir, ".checked_links.txt"), os.O_CREATE|os.O_WRONLY, 0644)
+	if a.TrustFilesystem {
+		// the cache wasn't read, so writing it would drop its entries
+		return nil
+	}
+	cacheFile, err := os.OpenFile(path.Join(a.OutputDir, checkedLinksFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
@@ -218,8 +1248,8 @@ func (a *Archiver) writeCheckedLinkCache() error {
 }
 
 func (a *Archiver) initCheckedLinkCache() error {
-	if a.checkedLinks == nil {
-		cacheFile, err := os.OpenFile(path.Join(*outputDir, ".checked_links.txt"), os.O_CREATE|os.O_RDONLY, 0644)
+	if a.checkedLinks == nil && !a.TrustFilesystem {
+		cacheFile, err := os.OpenFile(path.Join(a.OutputDir, checkedLinksFile), os.O_CREATE|os.O_RDONLY, 0644)
 		if err != nil {
 			return err
 		}
@@ -238,24 +1268,216 @@ func (a *Archiver) initCheckedLinkCache() error {
 }
 
 func (a *Archiver) isLinkCheckedBefore(linkID string) bool {
-	return a.checkedLinks[linkID]
+	return a.checkedLinks[linkID] || a.sharedCheckedLinks[linkID]
+}
+
+// hasLinkArchive reports whether the output directory has an archive of
+// linkID in any format, which is either a link directory or a single file.
+// A link directory holding only a screenshot or fetch log is left by a link
+// that failed, so it isn't an archive.
+func (a *Archiver) hasLinkArchive(linkID string) bool {
+	if _, err := os.Stat(archivePath(a.OutputDir, linkID, formatSingleFile)); err == nil {
+		return true
+	}
+	entries, err := os.ReadDir(path.Join(a.OutputDir, linkID))
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if entry.Name() != screenshotFile && entry.Name() != debugLogFile {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Archiver) setLastChecked(linkID string, t time.Time) {
+	if a.lastChecked != nil {
+		a.lastChecked[linkID] = t
+	}
+}
+
+// writeLastCheckedCache writes the time each link was last checked. This is
+// kept separate from the archived files so that checking an unchanged link
+// does not modify its archive.
+func (a *Archiver) writeLastCheckedCache() error {
+	b, err := yaml.Marshal(a.lastChecked)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(a.OutputDir, ".last_checked.yaml"), b, 0644)
+}
+
+func (a *Archiver) initLastCheckedCache() error {
+	if a.lastChecked == nil {
+		a.lastChecked = make(map[string]time.Time)
+		b, err := os.ReadFile(path.Join(a.OutputDir, ".last_checked.yaml"))
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return yaml.Unmarshal(b, &a.lastChecked)
+	}
+	return nil
+}
+
+// ErrInvalidLinkID is returned when LinkIDFunc returns an ID that cannot be
+// used as a file name in the output directory.
+var ErrInvalidLinkID = errors.New("invalid link ID")
+
+// Errors returned when validating arguments.
+var (
+	ErrMissingDirectory      = errors.New("input and output directory must be specified")
+	ErrUnsupportedFormat     = errors.New("unsupported format")
+	ErrUnsupportedBundle     = errors.New("unsupported bundle format")
+	ErrUnsupportedLogFormat  = errors.New("unsupported log format")
+	ErrBundleConflict        = errors.New("bundle cannot be combined with keep-history or sitemap-base-url")
+	ErrHistoryUnsupported    = errors.New("keep-history is not supported for the singlefile format")
+	ErrInvalidMaxIDLength    = errors.New("max-id-length must be positive")
+	ErrInvalidHashLength     = errors.New("hash-length must be between 1 and 64")
+	ErrInvalidSitemapBaseURL = errors.New("sitemap-base-url must be an absolute http or https URL")
+	ErrInputNotExist         = errors.New("input does not exist")
+	ErrInputNotDir           = errors.New("input is not a directory")
+	ErrOutputNotExist        = errors.New("output does not exist")
+	ErrOutputNotDir          = errors.New("output is not a directory")
+)
+
+// splitList splits a comma-separated flag value, ignoring empty items.
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// isFileUnchanged reports whether a markdown file can be skipped because it
+// hasn't been modified since it was last processed. Files are never skipped
+// unless running incrementally, and files that haven't been processed before
+// are always processed.
+func (a *Archiver) isFileUnchanged(filePath string, info os.FileInfo) bool {
+	// refreshing needs to see every link
+	if !a.Incremental || a.Force || a.Refresh {
+		return false
+	}
+	processedAt, ok := a.processedFiles[a.relativeInputPath(filePath)]
+	return ok && !info.ModTime().After(processedAt)
+}
+
+func (a *Archiver) setFileProcessed(filePath string, info os.FileInfo) {
+	if a.processedFiles != nil {
+		a.processedFiles[a.relativeInputPath(filePath)] = info.ModTime()
+	}
+}
+
+// relativeInputPath returns filePath relative to the input directory, so that
+// the processed file cache doesn't depend on how the input directory was
+// specified.
+func (a *Archiver) relativeInputPath(filePath string) string {
+	rel, err := filepath.Rel(a.InputDir, filePath)
+	if err != nil {
+		return filePath
+	}
+	return filepath.ToSlash(rel)
+}
+
+// writeProcessedFileCache writes the modification time of each markdown file
+// when it was last processed.
+func (a *Archiver) writeProcessedFileCache() error {
+	b, err := yaml.Marshal(a.processedFiles)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(a.OutputDir, ".processed_files.yaml"), b, 0644)
+}
+
+func (a *Archiver) initProcessedFileCache() error {
+	if a.processedFiles == nil {
+		a.processedFiles = make(map[string]time.Time)
+		b, err := os.ReadFile(path.Join(a.OutputDir, ".processed_files.yaml"))
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return yaml.Unmarshal(b, &a.processedFiles)
+	}
+	return nil
 }
 
 func validateArgs() error {
-	if *inputDir == "" || *outputDir == "" {
-		return errors.New("input and output directory must be specified")
+	if *inputDir != "" && *inputGlob != "" {
+		return ErrInputConflict
 	}
-	fileInfo, err := os.Stat(*inputDir)
-	if os.IsNotExist(err) {
-		return errors.New("input does not exist")
-	} else if !fileInfo.IsDir() {
-		return errors.New("input is not a directory")
+	if (*inputDir == "" && *inputGlob == "" && !*rebuildCache && *serveAddr == "") || (*outputDir == "" && !*listLinks) {
+		return ErrMissingDirectory
+	}
+	if *inputGlob != "" {
+		if err := validateInputGlob(*inputGlob); err != nil {
+			return err
+		}
+	}
+	if *format != formatHTML && *format != formatMarkdown && *format != formatSingleFile {
+		return fmt.Errorf("%w %q", ErrUnsupportedFormat, *format)
+	}
+	if _, err := newExtractor(*extractorName); err != nil {
+		return err
+	}
+	if *logFormat != logFormatText && *logFormat != logFormatJSON {
+		return fmt.Errorf("%w %q", ErrUnsupportedLogFormat, *logFormat)
+	}
+	if *keepHistory && *format == formatSingleFile {
+		return ErrHistoryUnsupported
+	}
+	if *bundleFormat != "" && *bundleFormat != bundleZip && *bundleFormat != bundleTarGz {
+		return fmt.Errorf("%w %q", ErrUnsupportedBundle, *bundleFormat)
+	}
+	if *bundleFormat != "" && (*keepHistory || *sitemapBaseURL != "") {
+		return ErrBundleConflict
+	}
+	if *maxIDLength < 1 {
+		return ErrInvalidMaxIDLength
+	}
+	if *since != "" {
+		if _, err := parseSince(*since, time.Now()); err != nil {
+			return err
+		}
 	}
-	fileInfo, err = os.Stat(*outputDir)
-	if os.IsNotExist(err) {
-		return errors.New("output does not exist")
+	if *hashLength < 1 || *hashLength > maxHashLength {
+		return ErrInvalidHashLength
+	}
+	if *sitemapBaseURL != "" {
+		if err := validateSitemapBaseURL(*sitemapBaseURL); err != nil {
+			return err
+		}
+	}
+	if *inputDir != stdinInput && *inputDir != "" {
+		fileInfo, err := os.Stat(*inputDir)
+		if os.IsNotExist(err) {
+			return ErrInputNotExist
+		} else if err != nil {
+			return err
+		} else if !fileInfo.IsDir() {
+			return ErrInputNotDir
+		}
+	}
+	if *listLinks {
+		// listing links doesn't use the output directory
+		return nil
+	}
+	fileInfo, err := os.Stat(*outputDir)
+	if os.IsNotExist(err) && *createOutput {
+		return os.MkdirAll(*outputDir, 0755)
+	} else if os.IsNotExist(err) {
+		return ErrOutputNotExist
+	} else if err != nil {
+		return err
 	} else if !fileInfo.IsDir() {
-		return errors.New("output is not a directory")
+		return ErrOutputNotDir
 	}
 	return nil
 }
@@ -263,16 +1485,177 @@ func validateArgs() error {
 func main() {
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+
+	headers, err := loadHostHeaders(*headersFile)
+	if err != nil {
+		fatal(err)
+	}
+	domainRules, err := loadDomainRules(*domainRulesFile)
+	if err != nil {
+		fatal(err)
+	}
+	// print the config before validating it, which may create the output
+	// directory
+	if *printConfigOnly {
+		if err := printConfig(os.Stdout, flag.CommandLine, headers, domainRules); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
 	if err := validateArgs(); err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+	extractor, err := newExtractor(*extractorName)
+	if err != nil {
+		fatal(err)
+	}
+	proxyURL, err := parseProxyURL(*proxy)
+	if err != nil {
+		fatal(err)
+	}
+	var sinceTime time.Time
+	if *since != "" {
+		sinceTime, err = parseSince(*since, time.Now())
+		if err != nil {
+			fatal(err)
+		}
+	}
+	// input files matching a glob are tracked relative to the directory
+	// the glob starts at
+	input := *inputDir
+	if *inputGlob != "" {
+		input = globBase(*inputGlob)
+	}
+	var tmpl *template.Template
+	if *templateFile != "" {
+		tmpl, err = loadTemplate(*templateFile)
+		if err != nil {
+			fatal(err)
+		}
 	}
 
 	archiver := Archiver{
-		InputDir:  *inputDir,
-		OutputDir: *outputDir,
+		InputDir:    input,
+		InputGlob:   *inputGlob,
+		OutputDir:   *outputDir,
+		Refresh:     *refresh,
+		MaxIDLength: *maxIDLength,
+		HashLength:  *hashLength,
+		Normalize:   *normalize,
+		StripParams: splitList(*stripParams),
+		Headers:     headers,
+		Verbose:     *verbose,
+		Proxy:       proxyURL,
+		Format:      *format,
+		SaveFavicon: *saveFavicon,
+		TagHeadings: *tagHeadings,
+		Incremental: *incremental,
+		Force:       *force,
+		Since:       sinceTime,
+		DebugLogs:   *debugLogs,
+		WaitLock:    *waitLock,
+
+		MinContentLength:   *minContentLength,
+		RenderJS:           *renderJS,
+		DedupeContent:      *dedupeContent,
+		SitemapBaseURL:     *sitemapBaseURL,
+		KeepHistory:        *keepHistory,
+		Bundle:             *bundleFormat,
+		Template:           tmpl,
+		ScanHTML:           *scanHTML,
+		AllowLocal:         *allowLocal,
+		AllowPrivateIPs:    *allowPrivateIPs,
+		MaxConnsPerHost:    *maxConnsPerHost,
+		MetricsFile:        *metricsFile,
+		TrustFilesystem:    *trustFilesystem,
+		LogFormat:          *logFormat,
+		MaxPageBytes:       *maxPageBytes,
+		SharedCaches:       splitList(*sharedCache),
+		UseCanonical:       *useCanonical,
+		GitDiffBase:        *gitDiff,
+		CheckpointArchives: *checkpointArchives,
+		FailOnError:        *failOnError,
+		MaxLinks:           *maxLinks,
+		FrontmatterFields:  splitList(*frontmatterFields),
+		DomainRules:        domainRules,
+		Sanitize:           *sanitize,
+		Screenshot:         *screenshot,
+		Extractor:          extractor,
+		SkipContentTypes:   splitList(*skipContentTypes),
+		ScreenshotWidth:    *screenshotWidth,
+		CheckpointInterval: *checkpointInterval,
 	}
-	err := archiver.Archive()
-	if err != nil {
-		log.Fatal(err)
+	if *listLinks {
+		links, err := archiver.ListLinks()
+		if err != nil {
+			fatal(err)
+		}
+		for _, link := range links {
+			fmt.Println(link)
+		}
+		return
+	}
+	if *rebuildCache {
+		recovered, err := archiver.RebuildCache()
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Recovered %d cache entries\n", recovered)
+		return
+	}
+	if *prune || *pruneDelete {
+		orphaned, err := archiver.Prune(*pruneDelete)
+		if err != nil {
+			fatal(err)
+		}
+		for _, linkID := range orphaned {
+			if *pruneDelete {
+				fmt.Printf("Pruned %s\n", linkID)
+			} else {
+				fmt.Printf("Orphaned %s\n", linkID)
+			}
+		}
+		return
+	}
+	ctx := context.Background()
+	if *maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *maxDuration)
+		defer cancel()
+	}
+	if *serveAddr != "" {
+		// serve until interrupted
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err = archiver.Serve(ctx, *serveAddr)
+		if errors.Is(err, context.Canceled) {
+			err = nil
+		}
+	} else if *watch {
+		// watch until interrupted
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err = archiver.Watch(ctx)
+		if errors.Is(err, context.Canceled) {
+			err = nil
+		}
+	} else {
+		err = archiver.ArchiveContext(ctx)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		archiver.logf(logEvent{Event: eventStopped, Err: err}, "stopped after exceeding max duration of %s", *maxDuration)
+		os.Exit(exitCode(err))
+	} else if errors.Is(err, ErrMaxLinksReached) {
+		// the link it stopped before was logged
+		os.Exit(exitCode(err))
+	} else if err != nil {
+		fatal(err)
 	}
 }
@@ -1,47 +1,1162 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-shiori/go-readability"
+	"gopkg.in/yaml.v2"
 )
 
-func TestParseLinksFromMarkdown(t *testing.T) {
+// interruptedReader returns some data and then fails, simulating a write that
+// is interrupted partway through.
+type interruptedReader struct {
+	data string
+	done bool
+}
+
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, errors.New("interrupted")
+	}
+	r.done = true
+	return copy(p, r.data), nil
+}
+
+func TestWriteArchive(t *testing.T) {
+	outputDir := t.TempDir()
+	err := writeArchive(outputDir, filepath.Join(outputDir, "example.com_abc", "index.html"), strings.NewReader("<p>hello</p>"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(outputDir, "example.com_abc", "index.html"))
+	if err != nil {
+		t.Fatalf("expected archived file, got %+v", err)
+	}
+	if string(b) != "<p>hello</p>" {
+		t.Errorf("expected %q, got %q", "<p>hello</p>", string(b))
+	}
+	assertNoTempFiles(t, outputDir)
+}
+
+func TestWriteArchiveInterrupted(t *testing.T) {
+	outputDir := t.TempDir()
+	err := writeArchive(outputDir, filepath.Join(outputDir, "example.com_abc", "index.html"), &interruptedReader{data: "<p>hel"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "example.com_abc")); !os.IsNotExist(err) {
+		t.Errorf("expected link directory to not exist, got %+v", err)
+	}
+	assertNoTempFiles(t, outputDir)
+}
+
+func TestWriteArchiveInterruptedKeepsExisting(t *testing.T) {
+	outputDir := t.TempDir()
+	if err := writeArchive(outputDir, filepath.Join(outputDir, "example.com_abc", "index.html"), strings.NewReader("<p>old</p>")); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	err := writeArchive(outputDir, filepath.Join(outputDir, "example.com_abc", "index.html"), &interruptedReader{data: "<p>ne"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	b, err := os.ReadFile(filepath.Join(outputDir, "example.com_abc", "index.html"))
+	if err != nil {
+		t.Fatalf("expected archived file, got %+v", err)
+	}
+	if string(b) != "<p>old</p>" {
+		t.Errorf("expected previous content to be kept, got %q", string(b))
+	}
+	assertNoTempFiles(t, outputDir)
+}
+
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".archive-*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) > 0 {
+		t.Errorf("expected no temporary files, got %+v", matches)
+	}
+}
+
+func TestArchiveRefreshUnchangedContent(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc](https://example.com)"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetchArticle := FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+		return readability.Article{Title: "Example", Content: "<p>stable</p>"}, nil
+	})
+	linkID, err := getLinkID("https://example.com", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archivedFilePath := archivePath(outputDir, linkID, formatHTML)
+
+	a := &Archiver{InputDir: inputDir, OutputDir: outputDir, Refresh: true, Fetcher: fetchArticle}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	metadata, err := readMetadata(archivedFilePath)
+	if err != nil {
+		t.Fatalf("expected metadata, got %+v", err)
+	}
+	if metadata.ContentHash != hashContent("<p>stable</p>") {
+		t.Errorf("expected content hash %q, got %q", hashContent("<p>stable</p>"), metadata.ContentHash)
+	}
+
+	// move the mtime into the past so an unexpected rewrite is detectable
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(archivedFilePath, past, past); err != nil {
+		t.Fatal(err)
+	}
+	firstChecked := a.lastChecked[linkID]
+
+	a = &Archiver{InputDir: inputDir, OutputDir: outputDir, Refresh: true, Fetcher: fetchArticle}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	info, err := os.Stat(archivedFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(past) {
+		t.Errorf("expected mtime %v to be unchanged, got %v", past, info.ModTime())
+	}
+	if !a.lastChecked[linkID].After(firstChecked) {
+		t.Errorf("expected last checked time to advance past %v, got %v", firstChecked, a.lastChecked[linkID])
+	}
+}
+
+func TestArchiveRefreshNotModified(t *testing.T) {
+	const etag = `"v1"`
+	const lastModified = "Wed, 21 Oct 2015 07:28:00 GMT"
+	var conditional []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == lastModified {
+			conditional = append(conditional, r.URL.Path)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Write([]byte(testArticleHTML))
+	}))
+	defer server.Close()
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	link := server.URL + "/article"
+	if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc]("+link+")"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	linkID, err := getLinkID(link, defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archivedFilePath := archivePath(outputDir, linkID, formatHTML)
+	newArchiver := func() *Archiver {
+		return &Archiver{InputDir: inputDir, OutputDir: outputDir, Refresh: true, AllowLocal: true, AllowPrivateIPs: true}
+	}
+
+	a := newArchiver()
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	metadata, err := readMetadata(archivedFilePath)
+	if err != nil {
+		t.Fatalf("expected metadata, got %+v", err)
+	}
+	if metadata.ETag != etag || metadata.LastModified != lastModified {
+		t.Errorf("expected validators %s and %s in metadata, got %+v", etag, lastModified, metadata)
+	}
+	if len(conditional) != 0 {
+		t.Errorf("expected no conditional requests before the link was archived, got %+v", conditional)
+	}
+
+	// move the mtime into the past so an unexpected rewrite is detectable
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(archivedFilePath, past, past); err != nil {
+		t.Fatal(err)
+	}
+	firstChecked := a.lastChecked[linkID]
+
+	a = newArchiver()
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if len(conditional) != 1 {
+		t.Errorf("expected a conditional request when refreshing, got %+v", conditional)
+	}
+	info, err := os.Stat(archivedFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(past) {
+		t.Errorf("expected mtime %v to be unchanged, got %v", past, info.ModTime())
+	}
+	if !a.lastChecked[linkID].After(firstChecked) {
+		t.Errorf("expected last checked time to advance past %v, got %v", firstChecked, a.lastChecked[linkID])
+	}
+}
+
+func TestArchiveRefreshChangedContent(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc](https://example.com)"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "<p>first</p>"
+	fetchArticle := FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+		return readability.Article{Title: "Example", Content: content}, nil
+	})
+	linkID, err := getLinkID("https://example.com", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []string{"<p>first</p>", "<p>second</p>"} {
+		content = c
+		a := &Archiver{InputDir: inputDir, OutputDir: outputDir, Refresh: true, Fetcher: fetchArticle}
+		if err := a.Archive(); err != nil {
+			t.Fatalf("expected nil error, got %+v", err)
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(outputDir, linkID, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(b), "<p>second</p>") {
+		t.Errorf("expected archive to be rewritten with new content, got %q", string(b))
+	}
+}
+
+func TestGetLinkID(t *testing.T) {
 	var tests = []struct {
-		name     string
-		given    string
-		expected []string
+		name      string
+		link      string
+		maxLength int
+		expected  string
 	}{
 		{
-			"single link",
-			" [abc](https://example.com)",
-			[]string{"https://example.com"},
+			"short link",
+			"https://example.com/abc",
+			defaultMaxIDLength,
+			"example.com__abc_",
+		},
+		{
+			"query parameters",
+			"https://example.com/abc?a=1&b=2",
+			defaultMaxIDLength,
+			"example.com__abc-a-1-b-2_",
+		},
+		{
+			"very long link",
+			"https://example.com/" + strings.Repeat("a", 200),
+			defaultMaxIDLength,
+			"example.com__" + strings.Repeat("a", 87) + "_",
+		},
+		{
+			"truncated at separator",
+			"https://example.com/abcd/efgh",
+			18,
+			"example.com__abcd_",
+		},
+		{
+			"truncated at multiple separators",
+			"https://example.com/abcd/-/efgh",
+			19,
+			"example.com__abcd_",
 		},
 		{
-			"multiple links",
-			" [abc](https://example.com) [bcd](https://example.org)",
-			[]string{"https://example.com", "https://example.org"},
+			"multibyte host",
+			"https://例え.jp/パス/abc",
+			defaultMaxIDLength,
+			".jp__E38391E382B9_abc_",
 		},
 		{
-			"http link",
-			" [abc](http://example.com)",
-			[]string{"http://example.com"},
+			"multibyte host truncated",
+			"https://例え.jp/" + strings.Repeat("パス/a", 100),
+			10,
+			".jp__E3839_",
 		},
 		{
-			"invalid link",
-			" [abc](http://)",
+			"truncated to one rune",
+			"https://example.com/abc",
+			1,
+			"e_",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := getLinkID(tt.link, tt.maxLength, defaultHashLength)
+			if err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			if !strings.HasPrefix(result, tt.expected) {
+				t.Errorf("(%+v): expected prefix %q, got %q", tt.link, tt.expected, result)
+			}
+			hash := result[strings.LastIndex(result, "_")+1:]
+			if len(hash) != 8 {
+				t.Errorf("(%+v): expected 8 character hash suffix, got %q", tt.link, result)
+			}
+			if strings.Contains(result, "__"+hash) || strings.Contains(result, "-_"+hash) || strings.Contains(result, "._"+hash) {
+				t.Errorf("(%+v): expected no dangling separator before hash, got %q", tt.link, result)
+			}
+			if n := len([]rune(strings.TrimSuffix(result, hash))); n > tt.maxLength+1 {
+				t.Errorf("(%+v): expected at most %d runes before hash, got %d", tt.link, tt.maxLength, n-1)
+			}
+		})
+	}
+}
+
+func TestGetLinkIDQueryParameters(t *testing.T) {
+	a, err := getLinkID("https://example.com/abc?a=1&b=2", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	b, err := getLinkID("https://example.com/abc?a=1&b=3", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if a == b {
+		t.Errorf("expected distinct link IDs, got %q for both", a)
+	}
+}
+
+func TestArchiveLinkIDCollision(t *testing.T) {
+	// with a one-character readable ID and hash, find two links whose IDs
+	// collide
+	first := "https://example.com/0"
+	firstID, err := getLinkID(first, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var second string
+	for i := 1; second == ""; i++ {
+		link := fmt.Sprintf("https://example.com/%d", i)
+		linkID, err := getLinkID(link, 1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if linkID == firstID {
+			second = link
+		}
+	}
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	markdown := fmt.Sprintf(" [first](%s)\n [second](%s)\n", first, second)
+	if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{
+		InputDir:    inputDir,
+		OutputDir:   outputDir,
+		MaxIDLength: 1,
+		HashLength:  1,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			return readability.Article{Title: link, Content: "<p>" + link + "</p>"}, nil
+		}),
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+
+	// a new run resolves the collision the same way from the archived
+	// metadata, regardless of the order the links are seen in
+	b := &Archiver{OutputDir: outputDir, MaxIDLength: 1, HashLength: 1}
+	for _, link := range []string{second, first} {
+		linkID, err := b.linkID(link)
+		if err != nil {
+			t.Fatalf("expected nil error, got %+v", err)
+		}
+		if link == first && linkID != firstID {
+			t.Errorf("expected link ID %q for %s, got %q", firstID, link, linkID)
+		}
+		if link == second && (linkID == firstID || !strings.HasPrefix(linkID, firstID)) {
+			t.Errorf("expected link ID extending %q for %s, got %q", firstID, link, linkID)
+		}
+		metadata, err := readMetadata(archivePath(outputDir, linkID, formatHTML))
+		if err != nil {
+			t.Fatalf("expected nil error, got %+v", err)
+		}
+		if metadata.URL != link {
+			t.Errorf("expected archive of %s at %q, got %s", link, linkID, metadata.URL)
+		}
+	}
+}
+
+func TestArchiverLinkIDFunc(t *testing.T) {
+	var tests = []struct {
+		name        string
+		linkIDFunc  func(url string) (string, error)
+		expected    string
+		expectedErr error
+	}{
+		{
+			"default",
+			nil,
+			"example.com__abc_d3cd3317",
 			nil,
 		},
+		{
+			"custom",
+			func(url string) (string, error) { return "custom-id", nil },
+			"custom-id",
+			nil,
+		},
+		{
+			"empty",
+			func(url string) (string, error) { return "", nil },
+			"",
+			ErrInvalidLinkID,
+		},
+		{
+			"path separator",
+			func(url string) (string, error) { return "../abc", nil },
+			"",
+			ErrInvalidLinkID,
+		},
+		{
+			"dotfile",
+			func(url string) (string, error) { return ".checked_links", nil },
+			"",
+			ErrInvalidLinkID,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Archiver{LinkIDFunc: tt.linkIDFunc}
+			result, err := a.linkID("https://example.com/abc")
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %+v, got %+v", tt.expectedErr, err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestArchiveLinkIDFunc(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc](https://example.com/abc)\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{
+		InputDir:   inputDir,
+		OutputDir:  outputDir,
+		LinkIDFunc: func(url string) (string, error) { return "custom-id", nil },
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "custom-id", "index.html")); err != nil {
+		t.Errorf("expected archive at custom link ID, got %+v", err)
+	}
+}
+
+func TestArchiveArticleMetadata(t *testing.T) {
+	publishedAt := time.Date(2021, 5, 20, 8, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		name     string
+		article  readability.Article
+		expected Metadata
+		omitted  []string
+	}{
+		{
+			"all fields set",
+			readability.Article{
+				Title:         "Example",
+				Byline:        "Jane Doe",
+				Excerpt:       "An example article.",
+				PublishedTime: &publishedAt,
+				Content:       "<p>abc</p>",
+			},
+			Metadata{
+				URL:           "https://example.com",
+				Title:         "Example",
+				Author:        "Jane Doe",
+				Excerpt:       "An example article.",
+				PublishedAt:   &publishedAt,
+				CaptureMethod: captureMethodFetch,
+				SourceFile:    "notes.md",
+			},
+			nil,
+		},
+		{
+			"empty fields omitted",
+			readability.Article{
+				Title:   "Example",
+				Content: "<p>abc</p>",
+			},
+			Metadata{
+				URL:           "https://example.com",
+				Title:         "Example",
+				CaptureMethod: captureMethodFetch,
+				SourceFile:    "notes.md",
+			},
+			[]string{"author:", "excerpt:", "published_at:"},
+		},
+		{
+			"link text as title fallback",
+			readability.Article{
+				Content: "<p>abc</p>",
+			},
+			Metadata{
+				URL:           "https://example.com",
+				Title:         "abc",
+				CaptureMethod: captureMethodFetch,
+				SourceFile:    "notes.md",
+			},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			outputDir := t.TempDir()
+			err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc](https://example.com)"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := &Archiver{
+				InputDir:  inputDir,
+				OutputDir: outputDir,
+				Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+					return tt.article, nil
+				}),
+			}
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			linkID, err := getLinkID("https://example.com", defaultMaxIDLength, defaultHashLength)
+			if err != nil {
+				t.Fatal(err)
+			}
+			archivedFilePath := archivePath(outputDir, linkID, formatHTML)
+			metadata, err := readMetadata(archivedFilePath)
+			if err != nil {
+				t.Fatalf("expected metadata, got %+v", err)
+			}
+			// not deterministic
+			metadata.ArchivedAt = time.Time{}
+			metadata.ContentHash = ""
+			if metadata.PublishedAt != nil {
+				utc := metadata.PublishedAt.UTC()
+				metadata.PublishedAt = &utc
+			}
+			if !reflect.DeepEqual(metadata, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, metadata)
+			}
+			b, err := os.ReadFile(archivedFilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range tt.omitted {
+				if strings.Contains(string(b), field) {
+					t.Errorf("expected %q to be omitted, got %q", field, string(b))
+				}
+			}
+		})
+	}
+}
+
+func TestArchiveDuplicateLinks(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	markdown := strings.Repeat(" [abc](https://example.com)\n", 5)
+	for _, name := range []string{"a.md", "b.md"} {
+		err := os.WriteFile(filepath.Join(inputDir, name), []byte(markdown), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fetched := 0
+	a := &Archiver{
+		InputDir:  inputDir,
+		OutputDir: outputDir,
+		// refresh so that the checked link cache doesn't hide repeated fetches
+		Refresh: true,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			fetched++
+			return readability.Article{}, errors.New("fetch failed")
+		}),
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if fetched != 1 {
+		t.Errorf("expected 1 fetch, got %d", fetched)
+	}
+}
+
+func TestArchiveContextCancelled(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	markdown := " [a](https://example.com/a)\n [b](https://example.com/b)\n"
+	err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var fetched []string
+	a := &Archiver{
+		InputDir:  inputDir,
+		OutputDir: outputDir,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			fetched = append(fetched, link)
+			// stop the run once the first link has been fetched
+			cancel()
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+	}
+	err = a.ArchiveContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %+v, got %+v", context.Canceled, err)
+	}
+	if !reflect.DeepEqual(fetched, []string{"https://example.com/a"}) {
+		t.Errorf("expected only the first link to be fetched, got %+v", fetched)
+	}
+
+	// the cache should be flushed for the link archived before the run
+	// was stopped
+	linkID, err := getLinkID("https://example.com/a", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(outputDir, ".checked_links.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked := strings.Fields(string(b)); !reflect.DeepEqual(checked, []string{linkID}) {
+		t.Errorf("expected checked links %+v, got %+v", []string{linkID}, checked)
+	}
+}
+
+func TestArchiveIncremental(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	notesPath := filepath.Join(inputDir, "notes.md")
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeNotes := func(markdown string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(notesPath, []byte(markdown), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(notesPath, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var fetched []string
+	run := func(force bool) {
+		t.Helper()
+		fetched = nil
+		a := &Archiver{
+			InputDir:    inputDir,
+			OutputDir:   outputDir,
+			Incremental: true,
+			Force:       force,
+			Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+				fetched = append(fetched, link)
+				return readability.Article{}, errors.New("fetch failed")
+			}),
+		}
+		if err := a.Archive(); err != nil {
+			t.Fatalf("expected nil error, got %+v", err)
+		}
+	}
+
+	// new files are always processed
+	writeNotes(" [a](https://example.com/a)\n", past)
+	run(false)
+	if !reflect.DeepEqual(fetched, []string{"https://example.com/a"}) {
+		t.Errorf("expected new file to be processed, got %+v", fetched)
+	}
+
+	// unmodified files are skipped
+	writeNotes(" [a](https://example.com/a)\n [b](https://example.com/b)\n", past)
+	run(false)
+	if len(fetched) != 0 {
+		t.Errorf("expected unmodified file to be skipped, got %+v", fetched)
+	}
+
+	// -force processes unmodified files
+	run(true)
+	if !reflect.DeepEqual(fetched, []string{"https://example.com/b"}) {
+		t.Errorf("expected forced run to pick up new link, got %+v", fetched)
+	}
+
+	// modified files pick up new links
+	writeNotes(" [a](https://example.com/a)\n [c](https://example.com/c)\n", past.Add(time.Minute))
+	run(false)
+	if !reflect.DeepEqual(fetched, []string{"https://example.com/c"}) {
+		t.Errorf("expected modified file to pick up new link, got %+v", fetched)
+	}
+}
+
+func TestArchiveContentTooShort(t *testing.T) {
+	var tests = []struct {
+		name             string
+		content          string
+		minContentLength int
+	}{
+		{"empty content", "", 0},
+		{"whitespace content", " \n ", 0},
+		{"below threshold", "<p>abc</p>", 20},
 	}
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := parseLinksFromMarkdown(tt.given)
+			inputDir := t.TempDir()
+			outputDir := t.TempDir()
+			err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc](https://example.com)"), 0644)
 			if err != nil {
+				t.Fatal(err)
+			}
+			a := &Archiver{
+				InputDir:         inputDir,
+				OutputDir:        outputDir,
+				MinContentLength: tt.minContentLength,
+				Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+					return readability.Article{Title: "Example", Content: tt.content}, nil
+				}),
+			}
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			linkID, err := getLinkID("https://example.com", defaultMaxIDLength, defaultHashLength)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join(outputDir, linkID)); !os.IsNotExist(err) {
+				t.Errorf("expected no archive, got %+v", err)
+			}
+			if a.isLinkCheckedBefore(linkID) {
+				t.Errorf("expected link to not be cached")
+			}
+		})
+	}
+}
+
+// setFlag sets a flag for the duration of a test.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	previous := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		flag.Set(name, previous)
+	})
+}
+
+func TestValidateArgsCreateOutput(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "nested", "output")
+	setFlag(t, "input", t.TempDir())
+	setFlag(t, "output", outputPath)
+
+	if err := validateArgs(); !errors.Is(err, ErrOutputNotExist) {
+		t.Fatalf("expected %+v, got %+v", ErrOutputNotExist, err)
+	}
+
+	setFlag(t, "create-output", "true")
+	if err := validateArgs(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		t.Fatalf("expected output to be created, got %+v", err)
+	}
+	if !info.IsDir() {
+		t.Errorf("expected output to be a directory")
+	}
+
+	// an existing file is still rejected
+	filePath := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(filePath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, "output", filePath)
+	if err := validateArgs(); !errors.Is(err, ErrOutputNotDir) {
+		t.Errorf("expected %+v, got %+v", ErrOutputNotDir, err)
+	}
+}
+
+func TestValidateArgs(t *testing.T) {
+	existingDir := t.TempDir()
+	existingFile := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(existingFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	var tests = []struct {
+		name     string
+		flags    map[string]string
+		expected error
+	}{
+		{"valid", map[string]string{"input": existingDir, "output": existingDir}, nil},
+		{"list links without output", map[string]string{"input": existingDir, "output": "", "list-links": "true"}, nil},
+		{"rebuild cache without input", map[string]string{"input": "", "output": existingDir, "rebuild-cache": "true"}, nil},
+		{"stdin input", map[string]string{"input": "-", "output": existingDir}, nil},
+		{"input glob", map[string]string{"input": "", "input-glob": filepath.Join(existingDir, "*.md"), "output": existingDir}, nil},
+		{"input and input glob", map[string]string{"input": existingDir, "input-glob": filepath.Join(existingDir, "*.md"), "output": existingDir}, ErrInputConflict},
+		{"invalid input glob", map[string]string{"input": "", "input-glob": "notes/[2024-*.md", "output": existingDir}, ErrInvalidInputGlob},
+		{"missing input flag", map[string]string{"input": "", "output": existingDir}, ErrMissingDirectory},
+		{"missing output flag", map[string]string{"input": existingDir, "output": ""}, ErrMissingDirectory},
+		{"unsupported format", map[string]string{"input": existingDir, "output": existingDir, "format": "pdf"}, ErrUnsupportedFormat},
+		{"unsupported extractor", map[string]string{"input": existingDir, "output": existingDir, "extractor": "mercury"}, ErrUnsupportedExtractor},
+		{"unsupported log format", map[string]string{"input": existingDir, "output": existingDir, "log-format": "xml"}, ErrUnsupportedLogFormat},
+		{"unsupported bundle", map[string]string{"input": existingDir, "output": existingDir, "bundle": "rar"}, ErrUnsupportedBundle},
+		{"bundle with history", map[string]string{"input": existingDir, "output": existingDir, "bundle": bundleZip, "keep-history": "true"}, ErrBundleConflict},
+		{"history with singlefile format", map[string]string{"input": existingDir, "output": existingDir, "format": formatSingleFile, "keep-history": "true"}, ErrHistoryUnsupported},
+		{"invalid max id length", map[string]string{"input": existingDir, "output": existingDir, "max-id-length": "0"}, ErrInvalidMaxIDLength},
+		{"invalid hash length", map[string]string{"input": existingDir, "output": existingDir, "hash-length": "65"}, ErrInvalidHashLength},
+		{"invalid since", map[string]string{"input": existingDir, "output": existingDir, "since": "last week"}, ErrInvalidSince},
+		{"invalid sitemap base url", map[string]string{"input": existingDir, "output": existingDir, "sitemap-base-url": "archive"}, ErrInvalidSitemapBaseURL},
+		{"input does not exist", map[string]string{"input": missing, "output": existingDir}, ErrInputNotExist},
+		{"input is not a directory", map[string]string{"input": existingFile, "output": existingDir}, ErrInputNotDir},
+		{"output does not exist", map[string]string{"input": existingDir, "output": missing}, ErrOutputNotExist},
+		{"output is not a directory", map[string]string{"input": existingDir, "output": existingFile}, ErrOutputNotDir},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.flags {
+				setFlag(t, name, value)
+			}
+			err := validateArgs()
+			if tt.expected == nil && err != nil {
 				t.Errorf("expected nil error, got %+v", err)
+			} else if !errors.Is(err, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, err)
 			}
-			if !reflect.DeepEqual(result, tt.expected) {
-				t.Errorf("(%+v): expected %+v, got %+v", tt.given, tt.expected, result)
+		})
+	}
+}
+
+func TestArchiveStdin(t *testing.T) {
+	outputDir := t.TempDir()
+	a := &Archiver{
+		InputDir:  stdinInput,
+		OutputDir: outputDir,
+		stdin:     strings.NewReader(" [abc](https://example.com)\n"),
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	linkID, err := getLinkID("https://example.com", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := readMetadata(archivePath(outputDir, linkID, formatHTML))
+	if err != nil {
+		t.Fatalf("expected metadata, got %+v", err)
+	}
+	if metadata.SourceFile != stdinSource {
+		t.Errorf("expected source file %q, got %q", stdinSource, metadata.SourceFile)
+	}
+}
+
+func TestArchiveEndToEnd(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	files := map[string]string{
+		"notes.md":     " [a](https://example.com/a)\n [fail](https://example.com/fail)\n",
+		"sub/notes.md": " [b](https://example.com/b)\n [a](https://example.com/a)\n",
+	}
+	for name, markdown := range files {
+		filePath := filepath.Join(inputDir, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(markdown), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fetched := make(map[string]int)
+	fetcher := FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+		if ctx == nil {
+			t.Errorf("expected context for %s", link)
+		}
+		fetched[link]++
+		if link == "https://example.com/fail" {
+			return readability.Article{}, errors.New("not found")
+		}
+		return readability.Article{Title: "Title of " + link, Content: "<p>" + link + "</p>"}, nil
+	})
+	a := &Archiver{InputDir: inputDir, OutputDir: outputDir, Fetcher: fetcher}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+
+	expected := map[string]string{
+		"https://example.com/a": "notes.md",
+		"https://example.com/b": filepath.Join("sub", "notes.md"),
+	}
+	var expectedChecked []string
+	for link, source := range expected {
+		if fetched[link] != 1 {
+			t.Errorf("expected %s to be fetched once, got %d", link, fetched[link])
+		}
+		linkID, err := getLinkID(link, defaultMaxIDLength, defaultHashLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedChecked = append(expectedChecked, linkID)
+		filePath := filepath.Join(outputDir, linkID, "index.html")
+		metadata, err := readMetadata(filePath)
+		if err != nil {
+			t.Fatalf("expected archive of %s at %s, got %+v", link, filePath, err)
+		}
+		if metadata.URL != link || metadata.Title != "Title of "+link || metadata.SourceFile != source {
+			t.Errorf("unexpected metadata for %s: %+v", link, metadata)
+		}
+		if metadata.CaptureMethod != captureMethodFetch {
+			t.Errorf("expected capture method %q, got %q", captureMethodFetch, metadata.CaptureMethod)
+		}
+		if metadata.ContentHash != hashContent("<p>"+link+"</p>") {
+			t.Errorf("expected content hash of article content, got %q", metadata.ContentHash)
+		}
+		b, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(string(b), "---\n<p>"+link+"</p>") {
+			t.Errorf("expected archived content after frontmatter, got %q", b)
+		}
+	}
+
+	// the failed link is cached as checked but isn't archived
+	failedID, err := getLinkID("https://example.com/fail", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedChecked = append(expectedChecked, failedID)
+	if _, err := os.Stat(filepath.Join(outputDir, failedID)); !os.IsNotExist(err) {
+		t.Errorf("expected no archive of failed link, got %+v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(outputDir, ".checked_links.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checked := strings.Fields(string(b))
+	sort.Strings(checked)
+	sort.Strings(expectedChecked)
+	if !reflect.DeepEqual(checked, expectedChecked) {
+		t.Errorf("expected checked links %+v, got %+v", expectedChecked, checked)
+	}
+	var lastChecked map[string]time.Time
+	b, err = os.ReadFile(filepath.Join(outputDir, ".last_checked.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(b, &lastChecked); err != nil {
+		t.Fatal(err)
+	}
+	if len(lastChecked) != len(expected) {
+		t.Errorf("expected last checked times of archived links only, got %+v", lastChecked)
+	}
+	for _, linkID := range expectedChecked {
+		if linkID != failedID && lastChecked[linkID].IsZero() {
+			t.Errorf("expected last checked time for %s, got %+v", linkID, lastChecked)
+		}
+	}
+
+	// a second run finds everything in the cache and fetches nothing
+	fetched = make(map[string]int)
+	a = &Archiver{InputDir: inputDir, OutputDir: outputDir, Fetcher: fetcher}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if len(fetched) != 0 {
+		t.Errorf("expected no fetches on second run, got %+v", fetched)
+	}
+}
+
+func TestArchiveTrustFilesystem(t *testing.T) {
+	var tests = []struct {
+		name            string
+		trustFilesystem bool
+		expectedFetched []string
+	}{
+		// the failed link in the cache isn't retried, and the merged link
+		// isn't in the cache so it's fetched again
+		{"cache", false, []string{"https://example.com/merged"}},
+		// the cache is ignored, so the failed link is retried but the
+		// links with archive directories aren't
+		{"trust filesystem", true, []string{"https://example.com/failed"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			outputDir := t.TempDir()
+			markdown := " [archived](https://example.com/archived)\n [failed](https://example.com/failed)\n [merged](https://example.com/merged)\n"
+			if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var checked []string
+			for _, link := range []string{"https://example.com/archived", "https://example.com/failed"} {
+				linkID, err := getLinkID(link, defaultMaxIDLength, defaultHashLength)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checked = append(checked, linkID)
+			}
+			cache := []byte(strings.Join(checked, "\n"))
+			if err := os.WriteFile(filepath.Join(outputDir, ".checked_links.txt"), cache, 0644); err != nil {
+				t.Fatal(err)
+			}
+			// the archived link was archived in another format, and the
+			// merged link has an archive directory but no cache entry
+			for _, link := range []string{"https://example.com/archived", "https://example.com/merged"} {
+				linkID, err := getLinkID(link, defaultMaxIDLength, defaultHashLength)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := writeArchive(outputDir, archivePath(outputDir, linkID, formatMarkdown), strings.NewReader("---\nurl: "+link+"\n---\nabc")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var fetched []string
+			a := &Archiver{
+				InputDir:        inputDir,
+				OutputDir:       outputDir,
+				TrustFilesystem: tt.trustFilesystem,
+				Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+					fetched = append(fetched, link)
+					return readability.Article{}, errors.New("fetch failed")
+				}),
+			}
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			if !reflect.DeepEqual(fetched, tt.expectedFetched) {
+				t.Errorf("expected fetches %+v, got %+v", tt.expectedFetched, fetched)
+			}
+			b, err := os.ReadFile(filepath.Join(outputDir, ".checked_links.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.trustFilesystem && string(b) != string(cache) {
+				t.Errorf("expected cache to be left untouched, got %q", b)
 			}
 		})
 	}
 }
+
+func TestArchiveFailOnError(t *testing.T) {
+	var tests = []struct {
+		name        string
+		failOnError bool
+		markdown    string
+		expectedErr error
+	}{
+		{"failed links", false, " [ok](https://example.com/ok)\n [fail](https://example.com/fail)\n", nil},
+		{"fail on error", true, " [ok](https://example.com/ok)\n [fail](https://example.com/fail)\n", ErrLinksFailed},
+		{"fail on error without failures", true, " [ok](https://example.com/ok)\n", nil},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(tt.markdown), 0644); err != nil {
+				t.Fatal(err)
+			}
+			a := &Archiver{
+				InputDir:    inputDir,
+				OutputDir:   t.TempDir(),
+				FailOnError: tt.failOnError,
+				Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+					if strings.HasSuffix(link, "/fail") {
+						return readability.Article{}, errors.New("fetch failed")
+					}
+					return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+				}),
+				stdout: &bytes.Buffer{},
+				stderr: &bytes.Buffer{},
+			}
+			err := a.Archive()
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected error %+v, got %+v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestArchiveMaxLinks(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	markdown := " [a](https://example.com/a)\n [b](https://example.com/b)\n [c](https://example.com/c)\n [d](https://example.com/d)\n"
+	if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var fetched []string
+	var stderr bytes.Buffer
+	a := &Archiver{
+		InputDir:  inputDir,
+		OutputDir: outputDir,
+		MaxLinks:  2,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			fetched = append(fetched, link)
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+		stdout: &bytes.Buffer{},
+		stderr: &stderr,
+	}
+	if err := a.Archive(); !errors.Is(err, ErrMaxLinksReached) {
+		t.Fatalf("expected error %+v, got %+v", ErrMaxLinksReached, err)
+	}
+	expected := []string{"https://example.com/a", "https://example.com/b"}
+	if !reflect.DeepEqual(fetched, expected) {
+		t.Errorf("expected %+v to be fetched, got %+v", expected, fetched)
+	}
+	if !strings.Contains(stderr.String(), "stopped before https://example.com/c") {
+		t.Errorf("expected the limit to be logged, got %q", stderr.String())
+	}
+
+	// the links archived before the limit are cached, so the next run
+	// continues with the rest
+	fetched = nil
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	expected = []string{"https://example.com/c", "https://example.com/d"}
+	if !reflect.DeepEqual(fetched, expected) {
+		t.Errorf("expected %+v to be fetched, got %+v", expected, fetched)
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// NOTE: regex has an edge case where it won't match a string starting with a
+// valid link. Must have at least one character between the start of line and
+// the link.
+//
+// [^!]                                 - Don't match if starts with `!` (link is an image)
+//     \[[^][]+\]                       - 1+ occurances of non-][ character
+//               \(                     - Opening brace containing the URL
+//
+// The URL itself is read by scanLinkDestination, since a regex can't match the
+// balanced parentheses that a URL may contain.
+var markdownLinkRegex = regexp.MustCompile(`[^!]\[[^][]+]\(`)
+
+// markdownHeadingRegex matches an ATX heading, capturing the heading markers
+// and the heading text without any closing sequence.
+var markdownHeadingRegex = regexp.MustCompile(`^ {0,3}(#{1,6})[ \t]+(.*?)(?:[ \t]+#+)?[ \t]*$`)
+
+// noArchiveRegex matches the comments that exclude links from archiving:
+//
+//   - <!-- no-archive --> excludes the links on the same line
+//   - <!-- no-archive:start --> and <!-- no-archive:end --> exclude the links
+//     between them, which may span several lines. A start without an end
+//     excludes the links up to the end of the file.
+var noArchiveRegex = regexp.MustCompile(`<!--[ \t]*no-archive(?::(start|end))?[ \t]*-->`)
+
+// codeFenceRegex matches a line opening or closing a fenced code block,
+// capturing the fence and the text following it.
+var codeFenceRegex = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})(.*)$")
+
+// blankLineRegex matches a blank line, which ends a paragraph.
+var blankLineRegex = regexp.MustCompile(`\n[ \t]*\r?\n`)
+
+// markdownLink is a link found in a markdown file.
+type markdownLink struct {
+	URL string
+	// Text is the text of the link, with its whitespace collapsed, or
+	// empty for links without text, such as those in frontmatter.
+	Text string
+	// Line is the line number, starting from 1, that the link appears on.
+	Line int
+	// Headings are the headings the link appears under, from the
+	// outermost to the nearest.
+	Headings []string
+}
+
+func parseLinksFromMarkdown(markdown string) (links []string, err error) {
+	for _, link := range parseLinksFromMarkdownWithPositions(markdown) {
+		links = append(links, link.URL)
+	}
+	return links, nil
+}
+
+// parseLinksFromMarkdownWithPositions returns the links in markdown along with
+// the line each link appears on and the headings it appears under.
+func parseLinksFromMarkdownWithPositions(markdown string) (links []markdownLink) {
+	lines := strings.Split(markdown, "\n")
+	codeSpans := markdownCodeSpans(markdown)
+	lineCode := &spanCursor{spans: codeSpans}
+	linkCode := &spanCursor{spans: codeSpans}
+
+	// headingsAt[i] are the headings in effect on line i+1
+	headingsAt := make([][]string, len(lines))
+	// headings[i] is the current heading of level i+1
+	var headings [6]string
+	var current []string
+	lineStart := 0
+	for i, line := range lines {
+		inCode := lineCode.contains(lineStart)
+		lineStart += len(line) + 1
+		if inCode {
+			headingsAt[i] = current
+			continue
+		}
+		if match := markdownHeadingRegex.FindStringSubmatch(line); match != nil {
+			level := len(match[1])
+			headings[level-1] = match[2]
+			for j := level; j < len(headings); j++ {
+				headings[j] = ""
+			}
+			current = nil
+			for _, heading := range headings {
+				if heading != "" {
+					current = append(current, heading)
+				}
+			}
+		}
+		headingsAt[i] = current
+	}
+
+	excludedLines, excludedSpans := noArchiveExclusions(markdown)
+
+	line := 1
+	offset := 0
+	for _, match := range findMarkdownLinks(markdown) {
+		start := match.Start
+		line += strings.Count(markdown[offset:start], "\n")
+		offset = start
+		if linkCode.contains(start) {
+			continue
+		}
+		endLine := line + strings.Count(markdown[start:match.End], "\n")
+		if isExcluded(line, endLine, start, excludedLines, excludedSpans) {
+			continue
+		}
+		links = append(links, markdownLink{
+			URL:      match.URL,
+			Text:     match.Text,
+			Line:     line,
+			Headings: headingsAt[line-1],
+		})
+	}
+	return links
+}
+
+// markdownLinkMatch is the position of a link in markdown.
+type markdownLinkMatch struct {
+	URL  string
+	Text string
+	// Start is the offset of the opening bracket of the link text, and End
+	// is the offset after the closing parenthesis of the link.
+	Start, End int
+}
+
+// findMarkdownLinks returns the inline links in markdown, in order.
+func findMarkdownLinks(markdown string) (matches []markdownLinkMatch) {
+	end := 0
+	for _, match := range markdownLinkRegex.FindAllStringIndex(markdown, -1) {
+		// the link starts after the character matched by [^!]
+		start := match[0] + 1
+		if start < end {
+			// within the destination of the previous link
+			continue
+		}
+		url, n, ok := scanLinkDestination(markdown[match[1]:])
+		if !ok {
+			continue
+		}
+		end = match[1] + n
+		// the text is between the brackets, before the opening parenthesis
+		text := strings.Join(strings.Fields(markdown[start+1:match[1]-2]), " ")
+		matches = append(matches, markdownLinkMatch{URL: url, Text: text, Start: start, End: end})
+	}
+	return matches
+}
+
+// scanLinkDestination reads the http or https URL at the start of s, which
+// follows the opening parenthesis of a link, up to the closing parenthesis. It
+// returns the URL and the length of s up to and including the closing
+// parenthesis. As in CommonMark, the URL may contain balanced parentheses, and
+// parentheses escaped with a backslash, or be wrapped in angle brackets.
+func scanLinkDestination(s string) (url string, n int, ok bool) {
+	if strings.HasPrefix(s, "<") {
+		return scanBracketedLinkDestination(s)
+	}
+	if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+		return "", 0, false
+	}
+	depth := 0
+	escaped := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) && (s[i+1] == '(' || s[i+1] == ')') {
+				escaped = true
+				i++
+			}
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			url = s[:i]
+			if strings.HasSuffix(url, "://") {
+				return "", 0, false
+			}
+			if escaped {
+				url = strings.NewReplacer(`\(`, "(", `\)`, ")").Replace(url)
+			}
+			return url, i + 1, true
+		}
+	}
+	return "", 0, false
+}
+
+// scanBracketedLinkDestination is like scanLinkDestination, for a URL
+// wrapped in angle brackets, such as <https://example.com/a b>. The URL may
+// contain spaces and parentheses, but not line breaks or unescaped angle
+// brackets. A title may follow the closing bracket.
+func scanBracketedLinkDestination(s string) (url string, n int, ok bool) {
+	end := -1
+	for i := 1; i < len(s) && end < 0; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '\n', '<':
+			return "", 0, false
+		case '>':
+			end = i
+		}
+	}
+	if end < 0 {
+		return "", 0, false
+	}
+	url = strings.NewReplacer(`\<`, "<", `\>`, ">").Replace(s[1:end])
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return "", 0, false
+	}
+	if strings.HasSuffix(url, "://") {
+		return "", 0, false
+	}
+	rest := s[end+1:]
+	if strings.HasPrefix(rest, ")") {
+		return url, end + 2, true
+	}
+	// the title, which must be separated from the URL by whitespace
+	if rest == "" || !strings.ContainsRune(" \t\n", rune(rest[0])) {
+		return "", 0, false
+	}
+	closing := strings.IndexByte(rest, ')')
+	if closing < 0 {
+		return "", 0, false
+	}
+	return url, end + 1 + closing + 1, true
+}
+
+// streamingParseMinBytes is the size above which markdown files are parsed
+// with parseLinksFromMarkdownReader instead of being read into memory.
+const streamingParseMinBytes = 4 << 20
+
+// parseLinksFromMarkdownReader is like parseLinksFromMarkdownWithPositions,
+// but reads markdown from r a line at a time rather than holding all of it in
+// memory. Links are found within single lines, so unlike
+// parseLinksFromMarkdownWithPositions it misses links whose text wraps onto
+// another line, and inline code spans don't continue across lines.
+func parseLinksFromMarkdownReader(r io.Reader) ([]markdownLink, error) {
+	reader := bufio.NewReader(r)
+	var links []markdownLink
+	var headings [6]string
+	var current []string
+	// fence is the opening fence of the current code block, if any
+	var fence string
+	// inNoArchive is set within a no-archive:start span
+	inNoArchive := false
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line == "" && err == io.EOF {
+			return links, nil
+		}
+		text := strings.TrimRight(line, "\r\n")
+
+		// a code block includes its opening and closing fences
+		inCode := fence != ""
+		if match := codeFenceRegex.FindStringSubmatch(text); match != nil {
+			switch {
+			case fence == "":
+				if match[1][0] != '`' || !strings.Contains(match[2], "`") {
+					fence = match[1]
+					inCode = true
+				}
+			case match[1][0] == fence[0] && len(match[1]) >= len(fence) && strings.TrimSpace(match[2]) == "":
+				fence = ""
+			}
+		}
+
+		if match := markdownHeadingRegex.FindStringSubmatch(text); match != nil && !inCode {
+			level := len(match[1])
+			headings[level-1] = match[2]
+			for j := level; j < len(headings); j++ {
+				headings[j] = ""
+			}
+			current = nil
+			for _, heading := range headings {
+				if heading != "" {
+					current = append(current, heading)
+				}
+			}
+		}
+
+		// exclusions are the byte ranges of the line excluded by
+		// no-archive spans
+		var exclusions [][2]int
+		excludedLine := false
+		spanStart := 0
+		for _, match := range noArchiveRegex.FindAllStringSubmatchIndex(text, -1) {
+			switch {
+			case match[2] < 0:
+				excludedLine = true
+			case text[match[2]:match[3]] == "start":
+				if !inNoArchive {
+					inNoArchive = true
+					spanStart = match[1]
+				}
+			case inNoArchive:
+				exclusions = append(exclusions, [2]int{spanStart, match[0]})
+				inNoArchive = false
+			}
+		}
+		if inNoArchive {
+			exclusions = append(exclusions, [2]int{spanStart, len(text)})
+		}
+
+		if !inCode && !excludedLine {
+			codeSpans := inlineCodeSpans(text, 0)
+			// match the regex as if the line followed a newline, as it
+			// does in parseLinksFromMarkdownWithPositions, so that a link
+			// at the start of a line is found
+			prefix := "\n"
+			if lineNumber == 1 {
+				prefix = ""
+			}
+			for _, match := range findMarkdownLinks(prefix + text) {
+				start := match.Start - len(prefix)
+				if inSpans(start, codeSpans) || inSpans(start, exclusions) {
+					continue
+				}
+				links = append(links, markdownLink{
+					URL:      match.URL,
+					Text:     match.Text,
+					Line:     lineNumber,
+					Headings: current,
+				})
+			}
+		}
+		if err == io.EOF {
+			return links, nil
+		}
+	}
+}
+
+// noArchiveExclusions returns the lines and the byte ranges of markdown that
+// are excluded from archiving by noArchiveRegex comments.
+func noArchiveExclusions(markdown string) (lines map[int]bool, spans [][2]int) {
+	spanStart := -1
+	for _, match := range noArchiveRegex.FindAllStringSubmatchIndex(markdown, -1) {
+		switch {
+		case match[2] < 0:
+			if lines == nil {
+				lines = make(map[int]bool)
+			}
+			lines[strings.Count(markdown[:match[0]], "\n")+1] = true
+		case markdown[match[2]:match[3]] == "start":
+			if spanStart < 0 {
+				spanStart = match[1]
+			}
+		case spanStart >= 0:
+			spans = append(spans, [2]int{spanStart, match[0]})
+			spanStart = -1
+		}
+	}
+	if spanStart >= 0 {
+		spans = append(spans, [2]int{spanStart, len(markdown)})
+	}
+	return lines, spans
+}
+
+// isExcluded reports whether a link spanning startLine to endLine, and
+// starting at offset, is excluded from archiving.
+func isExcluded(startLine, endLine, offset int, lines map[int]bool, spans [][2]int) bool {
+	for l := startLine; l <= endLine; l++ {
+		if lines[l] {
+			return true
+		}
+	}
+	return inSpans(offset, spans)
+}
+
+// inSpans reports whether offset is within any of the given byte ranges.
+func inSpans(offset int, spans [][2]int) bool {
+	for _, span := range spans {
+		if offset >= span[0] && offset < span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// spanCursor reports whether offsets are within sorted, non-overlapping byte
+// ranges. Offsets must be given in increasing order, which lets each range be
+// passed over once rather than searched for every offset.
+type spanCursor struct {
+	spans [][2]int
+	next  int
+}
+
+func (c *spanCursor) contains(offset int) bool {
+	for c.next < len(c.spans) && c.spans[c.next][1] <= offset {
+		c.next++
+	}
+	return c.next < len(c.spans) && offset >= c.spans[c.next][0]
+}
+
+// markdownCodeSpans returns the byte ranges of markdown that are code, either
+// fenced code blocks or inline code spans. Links in code are examples rather
+// than references, so they aren't archived.
+func markdownCodeSpans(markdown string) (spans [][2]int) {
+	// fence is the opening fence of the current code block, if any
+	var fence string
+	fenceStart := 0
+	// proseStart is the start of the text since the last code block
+	proseStart := 0
+	offset := 0
+	for _, line := range strings.SplitAfter(markdown, "\n") {
+		match := codeFenceRegex.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+		switch {
+		case match == nil:
+		case fence == "":
+			// the info string of a backtick fence can't contain
+			// backticks, otherwise the line is inline code
+			if match[1][0] == '`' && strings.Contains(match[2], "`") {
+				break
+			}
+			spans = append(spans, inlineCodeSpans(markdown[proseStart:offset], proseStart)...)
+			fence = match[1]
+			fenceStart = offset
+		case match[1][0] == fence[0] && len(match[1]) >= len(fence) && strings.TrimSpace(match[2]) == "":
+			spans = append(spans, [2]int{fenceStart, offset + len(line)})
+			fence = ""
+			proseStart = offset + len(line)
+		}
+		offset += len(line)
+	}
+	// an unclosed code block extends to the end of the file
+	if fence != "" {
+		return append(spans, [2]int{fenceStart, len(markdown)})
+	}
+	return append(spans, inlineCodeSpans(markdown[proseStart:], proseStart)...)
+}
+
+// inlineCodeSpans returns the byte ranges of the inline code spans in text,
+// offset by base. Code spans don't continue across paragraphs.
+func inlineCodeSpans(text string, base int) (spans [][2]int) {
+	start := 0
+	for _, blank := range blankLineRegex.FindAllStringIndex(text, -1) {
+		spans = append(spans, paragraphCodeSpans(text[start:blank[0]], base+start)...)
+		start = blank[1]
+	}
+	return append(spans, paragraphCodeSpans(text[start:], base+start)...)
+}
+
+// paragraphCodeSpans returns the byte ranges of the inline code spans in a
+// paragraph, offset by base. A code span starts with a run of backticks and
+// ends at the next run of the same length. A run without a matching run is
+// literal text.
+func paragraphCodeSpans(text string, base int) (spans [][2]int) {
+	backtickRun := func(i int) int {
+		n := 0
+		for i+n < len(text) && text[i+n] == '`' {
+			n++
+		}
+		return n
+	}
+	for i := 0; i < len(text); {
+		if text[i] != '`' {
+			i++
+			continue
+		}
+		n := backtickRun(i)
+		end := -1
+		for j := i + n; j < len(text); {
+			if text[j] != '`' {
+				j++
+				continue
+			}
+			m := backtickRun(j)
+			if m == n {
+				end = j + m
+				break
+			}
+			j += m
+		}
+		if end < 0 {
+			i += n
+			continue
+		}
+		spans = append(spans, [2]int{base + i, base + end})
+		i = end
+	}
+	return spans
+}
+
+// dedupeLinks removes repeated URLs from links, keeping the first occurrence
+// of each.
+func dedupeLinks(links []markdownLink) []markdownLink {
+	seen := make(map[string]bool, len(links))
+	deduped := links[:0]
+	for _, link := range links {
+		if seen[link.URL] {
+			continue
+		}
+		seen[link.URL] = true
+		deduped = append(deduped, link)
+	}
+	return deduped
+}
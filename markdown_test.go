@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLinksFromMarkdown(t *testing.T) {
+	var tests = []struct {
+		name     string
+		given    string
+		expected []string
+	}{
+		{
+			"single link",
+			" [abc](https://example.com)",
+			[]string{"https://example.com"},
+		},
+		{
+			"multiple links",
+			" [abc](https://example.com) [bcd](https://example.org)",
+			[]string{"https://example.com", "https://example.org"},
+		},
+		{
+			"http link",
+			" [abc](http://example.com)",
+			[]string{"http://example.com"},
+		},
+		{
+			"invalid link",
+			" [abc](http://)",
+			nil,
+		},
+		{
+			"balanced parentheses",
+			" [Go](https://en.wikipedia.org/wiki/Go_(programming_language)) and [b](https://example.com/a_(b_(c))_d)",
+			[]string{"https://en.wikipedia.org/wiki/Go_(programming_language)", "https://example.com/a_(b_(c))_d"},
+		},
+		{
+			"parentheses around link",
+			" ([Go](https://en.wikipedia.org/wiki/Go_(programming_language)))",
+			[]string{"https://en.wikipedia.org/wiki/Go_(programming_language)"},
+		},
+		{
+			"escaped parenthesis",
+			` [abc](https://example.com/a\)b)`,
+			[]string{"https://example.com/a)b"},
+		},
+		{
+			"angle brackets",
+			" [abc](<https://example.com/with spaces>) [b](<https://example.com/a)b>)",
+			[]string{"https://example.com/with spaces", "https://example.com/a)b"},
+		},
+		{
+			"angle brackets with title",
+			` [abc](<https://example.com/with spaces> "Title")`,
+			[]string{"https://example.com/with spaces"},
+		},
+		{
+			"unclosed angle bracket",
+			" [abc](<https://example.com/with spaces) [b](https://example.com/b)",
+			[]string{"https://example.com/b"},
+		},
+		{
+			"angle brackets around non-http link",
+			" [abc](<mailto:a@example.com>)",
+			nil,
+		},
+		{
+			"unbalanced parentheses",
+			" [abc](https://example.com/a_(b",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseLinksFromMarkdown(tt.given)
+			if err != nil {
+				t.Errorf("expected nil error, got %+v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("(%+v): expected %+v, got %+v", tt.given, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseLinksFromMarkdownWithPositions(t *testing.T) {
+	markdown := `Intro text, not linked.
+
+# Go
+
+[abc](https://example.com/go)
+
+## Concurrency ##
+
+Read [the memory model](https://example.com/memory-model).
+
+### Channels
+
+ [channels](https://example.com/channels)
+
+## Tooling
+
+[vet](https://example.com/vet)
+
+#not-a-heading [tag](https://example.com/tag)
+
+A [link with
+wrapped text](https://example.com/wrapped).
+`
+	expected := []markdownLink{
+		{URL: "https://example.com/go", Text: "abc", Line: 5, Headings: []string{"Go"}},
+		{URL: "https://example.com/memory-model", Text: "the memory model", Line: 9, Headings: []string{"Go", "Concurrency"}},
+		{URL: "https://example.com/channels", Text: "channels", Line: 13, Headings: []string{"Go", "Concurrency", "Channels"}},
+		{URL: "https://example.com/vet", Text: "vet", Line: 17, Headings: []string{"Go", "Tooling"}},
+		{URL: "https://example.com/tag", Text: "tag", Line: 19, Headings: []string{"Go", "Tooling"}},
+		{URL: "https://example.com/wrapped", Text: "link with wrapped text", Line: 21, Headings: []string{"Go", "Tooling"}},
+	}
+	result := parseLinksFromMarkdownWithPositions(markdown)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestParseLinksFromMarkdownNoArchive(t *testing.T) {
+	markdown := ` [kept](https://example.com/a)
+ [skipped](https://example.com/b) <!-- no-archive -->
+ [kept](https://example.com/c)
+ <!--no-archive--> [skipped](https://example.com/d) and [skipped](https://example.com/e)
+ [kept](https://example.com/f) <!-- archive -->
+ [skipped, wrapped
+text](https://example.com/g) <!-- no-archive -->
+<!-- no-archive:start -->
+ [skipped](https://example.com/h)
+ [skipped](https://example.com/i)
+<!-- no-archive:end --> [kept](https://example.com/j)
+ [kept](https://example.com/k)
+<!-- no-archive:start --> [skipped](https://example.com/l)
+`
+	expected := []markdownLink{
+		{URL: "https://example.com/a", Text: "kept", Line: 1},
+		{URL: "https://example.com/c", Text: "kept", Line: 3},
+		{URL: "https://example.com/f", Text: "kept", Line: 5},
+		{URL: "https://example.com/j", Text: "kept", Line: 11},
+		{URL: "https://example.com/k", Text: "kept", Line: 12},
+	}
+	result := parseLinksFromMarkdownWithPositions(markdown)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestParseLinksFromMarkdownCode(t *testing.T) {
+	markdown := "# Setup\n" +
+		"\n" +
+		"```markdown\n" +
+		"# Not a heading\n" +
+		" [example](https://example.com/fenced)\n" +
+		"```\n" +
+		" [prose](https://example.com/after-fence)\n" +
+		"~~~~\n" +
+		" [example](https://example.com/tilde)\n" +
+		"~~~\n" +
+		"~~~~\n" +
+		" [prose](https://example.com/after-tilde)\n" +
+		"Use `[example](https://example.com/inline)` or ``[a](https://example.com/double) ` `` and\n" +
+		" [prose](https://example.com/after-inline), and an unmatched ` [prose](https://example.com/unmatched)\n" +
+		"\n" +
+		"A [code `span`](https://example.com/code-text)\n" +
+		"```\n" +
+		" [example](https://example.com/unclosed)\n"
+	expected := []markdownLink{
+		{URL: "https://example.com/after-fence", Text: "prose", Line: 7, Headings: []string{"Setup"}},
+		{URL: "https://example.com/after-tilde", Text: "prose", Line: 12, Headings: []string{"Setup"}},
+		{URL: "https://example.com/after-inline", Text: "prose", Line: 14, Headings: []string{"Setup"}},
+		{URL: "https://example.com/unmatched", Text: "prose", Line: 14, Headings: []string{"Setup"}},
+		{URL: "https://example.com/code-text", Text: "code `span`", Line: 16, Headings: []string{"Setup"}},
+	}
+	result := parseLinksFromMarkdownWithPositions(markdown)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestParseLinksFromMarkdownReader(t *testing.T) {
+	markdown := "[first](https://example.com/first)\n" +
+		"# Notes\n" +
+		"\n" +
+		" [a](https://example.com/a) and [b](https://example.com/b)\r\n" +
+		"```\n" +
+		"# Not a heading\n" +
+		" [fenced](https://example.com/fenced)\n" +
+		"```\n" +
+		"## Code\n" +
+		"Use `[inline](https://example.com/inline)` or [c](https://example.com/c)\n" +
+		" [skipped](https://example.com/skipped) <!-- no-archive -->\n" +
+		"<!-- no-archive:start --> [skipped](https://example.com/span)\n" +
+		" [skipped](https://example.com/span2) <!-- no-archive:end --> [d](https://example.com/d)\n" +
+		" [e](https://example.com/e)"
+	expected := []markdownLink{
+		{URL: "https://example.com/a", Text: "a", Line: 4, Headings: []string{"Notes"}},
+		{URL: "https://example.com/b", Text: "b", Line: 4, Headings: []string{"Notes"}},
+		{URL: "https://example.com/c", Text: "c", Line: 10, Headings: []string{"Notes", "Code"}},
+		{URL: "https://example.com/d", Text: "d", Line: 13, Headings: []string{"Notes", "Code"}},
+		{URL: "https://example.com/e", Text: "e", Line: 14, Headings: []string{"Notes", "Code"}},
+	}
+	result, err := parseLinksFromMarkdownReader(strings.NewReader(markdown))
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+	// links that don't wrap are parsed the same as in memory
+	if inMemory := parseLinksFromMarkdownWithPositions(markdown); !reflect.DeepEqual(result, inMemory) {
+		t.Errorf("expected %+v, got %+v", inMemory, result)
+	}
+}
+
+// largeMarkdown returns markdown with n lines of prose, code and links.
+func largeMarkdown(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		switch i % 10 {
+		case 0:
+			fmt.Fprintf(&b, "## Section %d\n", i)
+		case 5:
+			fmt.Fprintf(&b, "See `code` and [link %d](https://example.com/%d) for details.\n", i, i)
+		default:
+			b.WriteString("Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor.\n")
+		}
+	}
+	return b.String()
+}
+
+func BenchmarkParseLinksFromMarkdownWithPositions(b *testing.B) {
+	markdown := largeMarkdown(100000)
+	b.SetBytes(int64(len(markdown)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseLinksFromMarkdownWithPositions(markdown)
+	}
+}
+
+func BenchmarkParseLinksFromMarkdownReader(b *testing.B) {
+	markdown := largeMarkdown(100000)
+	b.SetBytes(int64(len(markdown)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseLinksFromMarkdownReader(strings.NewReader(markdown)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDedupeLinks(t *testing.T) {
+	links := []markdownLink{
+		{URL: "https://example.com", Line: 1},
+		{URL: "https://example.org", Line: 2},
+		{URL: "https://example.com", Line: 3},
+		{URL: "https://example.com", Line: 4},
+	}
+	expected := []markdownLink{
+		{URL: "https://example.com", Line: 1},
+		{URL: "https://example.org", Line: 2},
+	}
+	result := dedupeLinks(links)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
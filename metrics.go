@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runMetrics are the metrics of a single run.
+type runMetrics struct {
+	mu            sync.Mutex
+	archived      int
+	failures      map[string]int
+	fetches       int
+	fetchDuration time.Duration
+}
+
+func (m *runMetrics) addArchived() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.archived++
+}
+
+func (m *runMetrics) addFailure(link string) {
+	host := ""
+	if u, err := url.Parse(link); err == nil {
+		host = u.Hostname()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failures == nil {
+		m.failures = make(map[string]int)
+	}
+	m.failures[host]++
+}
+
+// failureCount returns the number of links that couldn't be archived.
+func (m *runMetrics) failureCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, count := range m.failures {
+		n += count
+	}
+	return n
+}
+
+func (m *runMetrics) addFetch(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fetches++
+	m.fetchDuration += d
+}
+
+// writeMetrics writes the metrics of the run that finished at finishedAt to
+// MetricsFile, in the Prometheus text exposition format, so that the file can
+// be collected by node_exporter's textfile collector. Each run starts its
+// metrics from zero and replaces the file, so they are gauges of the last run
+// rather than counters.
+func (a *Archiver) writeMetrics(finishedAt time.Time) error {
+	m := &a.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var buf bytes.Buffer
+	writeMetricHeader(&buf, "archiver_last_run_archived", "gauge", "Links archived in the last run.")
+	fmt.Fprintf(&buf, "archiver_last_run_archived %d\n", m.archived)
+
+	writeMetricHeader(&buf, "archiver_last_run_failures", "gauge", "Links that could not be archived in the last run, by host.")
+	hosts := make([]string, 0, len(m.failures))
+	for host := range m.failures {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		fmt.Fprintf(&buf, "archiver_last_run_failures{host=\"%s\"} %d\n", escapeLabelValue(host), m.failures[host])
+	}
+
+	writeMetricHeader(&buf, "archiver_last_run_fetches", "gauge", "Links fetched in the last run.")
+	fmt.Fprintf(&buf, "archiver_last_run_fetches %d\n", m.fetches)
+
+	writeMetricHeader(&buf, "archiver_last_run_fetch_duration_seconds", "gauge", "Total time spent fetching links in the last run.")
+	fmt.Fprintf(&buf, "archiver_last_run_fetch_duration_seconds %g\n", m.fetchDuration.Seconds())
+
+	var average float64
+	if m.fetches > 0 {
+		average = m.fetchDuration.Seconds() / float64(m.fetches)
+	}
+	writeMetricHeader(&buf, "archiver_last_run_fetch_duration_seconds_avg", "gauge", "Average time to fetch a link in the last run, or zero if none were fetched.")
+	fmt.Fprintf(&buf, "archiver_last_run_fetch_duration_seconds_avg %g\n", average)
+
+	writeMetricHeader(&buf, "archiver_last_run_timestamp_seconds", "gauge", "Unix time the last run finished.")
+	fmt.Fprintf(&buf, "archiver_last_run_timestamp_seconds %d\n", finishedAt.Unix())
+
+	return writeArchive(filepath.Dir(a.MetricsFile), a.MetricsFile, &buf)
+}
+
+func writeMetricHeader(buf *bytes.Buffer, name, metricType, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// escapeLabelValue escapes a label value for the Prometheus text format.
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
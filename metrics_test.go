@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestArchiveMetricsFile(t *testing.T) {
+	inputDir := t.TempDir()
+	markdown := " [a](https://example.com/a)\n [b](https://example.com/b)\n [c](https://example.org/c)\n [d](https://example.org/d)\n"
+	err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricsFile := filepath.Join(t.TempDir(), "archiver.prom")
+	a := &Archiver{
+		InputDir:    inputDir,
+		OutputDir:   t.TempDir(),
+		MetricsFile: metricsFile,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			if strings.HasPrefix(link, "https://example.org/") || link == "https://example.com/b" {
+				return readability.Article{}, errors.New("fetch failed")
+			}
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	b, err := os.ReadFile(metricsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := string(b)
+	for _, expected := range []string{
+		"# TYPE archiver_last_run_archived gauge\narchiver_last_run_archived 1\n",
+		"# TYPE archiver_last_run_failures gauge\n",
+		"archiver_last_run_failures{host=\"example.com\"} 1\narchiver_last_run_failures{host=\"example.org\"} 2\n",
+		"archiver_last_run_fetches 4\n",
+		"# TYPE archiver_last_run_fetch_duration_seconds gauge\n",
+		"# TYPE archiver_last_run_fetch_duration_seconds_avg gauge\narchiver_last_run_fetch_duration_seconds_avg ",
+		"archiver_last_run_timestamp_seconds ",
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("expected metrics to contain %q, got %s", expected, metrics)
+		}
+	}
+}
+
+func TestWriteMetricsFetchDurationAverage(t *testing.T) {
+	var tests = []struct {
+		name          string
+		fetches       int
+		fetchDuration time.Duration
+		expected      string
+	}{
+		{"fetched", 4, 3 * time.Second, "archiver_last_run_fetch_duration_seconds_avg 0.75\n"},
+		{"nothing fetched", 0, 0, "archiver_last_run_fetch_duration_seconds_avg 0\n"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			metricsFile := filepath.Join(t.TempDir(), "archiver.prom")
+			a := &Archiver{MetricsFile: metricsFile}
+			a.metrics.fetches = tt.fetches
+			a.metrics.fetchDuration = tt.fetchDuration
+			if err := a.writeMetrics(time.Now()); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			b, err := os.ReadFile(metricsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(b), tt.expected) {
+				t.Errorf("expected metrics to contain %q, got %s", tt.expected, b)
+			}
+		})
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	result := escapeLabelValue("a\"b\\c\nd")
+	expected := `a\"b\\c\nd`
+	if result != expected {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// defaultStripParams are the query parameters removed from links when URL
+// normalization is enabled. A trailing `*` matches any parameter with the
+// given prefix.
+var defaultStripParams = []string{
+	"utm_*",
+	"fbclid",
+	"gclid",
+	"dclid",
+	"msclkid",
+	"mc_cid",
+	"mc_eid",
+	"igshid",
+	"yclid",
+}
+
+// normalizeURL rewrites link into a canonical form so that variants of the same
+// URL map to the same archive. It lowercases the host, removes the `www.`
+// prefix and default ports, strips query parameters matching stripParams,
+// removes trailing slashes from the path, including the slash of the root
+// path, and drops the fragment. The result is only used to compute the IDs
+// of links, so it doesn't need to be fetchable.
+func normalizeURL(link string, stripParams []string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// keep the brackets of IPv6 addresses
+		host = "[" + host + "]"
+	}
+	u.Host = host
+
+	// filter the raw query rather than re-encoding it, so that the order
+	// and encoding of the remaining parameters are preserved
+	if u.RawQuery != "" {
+		var params []string
+		for _, param := range strings.Split(u.RawQuery, "&") {
+			if param == "" {
+				continue
+			}
+			key := param
+			if i := strings.Index(param, "="); i >= 0 {
+				key = param[:i]
+			}
+			if unescaped, err := url.QueryUnescape(key); err == nil {
+				key = unescaped
+			}
+			if !matchesParam(key, stripParams) {
+				params = append(params, param)
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+	u.ForceQuery = false
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	return u.String(), nil
+}
+
+// matchesParam reports whether key matches any of the given parameter
+// patterns.
+func matchesParam(key string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if key == pattern {
+			return true
+		}
+	}
+	return false
+}
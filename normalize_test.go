@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	var tests = []struct {
+		name     string
+		given    string
+		expected string
+	}{
+		{
+			"already normalized",
+			"https://example.com/abc",
+			"https://example.com/abc",
+		},
+		{
+			"tracking params",
+			"https://example.com/abc?utm_source=feed&id=1&utm_medium=rss&fbclid=xyz",
+			"https://example.com/abc?id=1",
+		},
+		{
+			"only tracking params",
+			"https://example.com/abc?utm_source=feed",
+			"https://example.com/abc",
+		},
+		{
+			"uppercase host",
+			"https://EXAMPLE.com/Abc",
+			"https://example.com/Abc",
+		},
+		{
+			"www prefix",
+			"https://www.example.com/abc",
+			"https://example.com/abc",
+		},
+		{
+			"default https port",
+			"https://example.com:443/abc",
+			"https://example.com/abc",
+		},
+		{
+			"default http port",
+			"http://example.com:80/abc",
+			"http://example.com/abc",
+		},
+		{
+			"non-default port",
+			"https://example.com:8443/abc",
+			"https://example.com:8443/abc",
+		},
+		{
+			"IPv6 host",
+			"http://[::1]:8080/abc",
+			"http://[::1]:8080/abc",
+		},
+		{
+			"IPv6 host with default port",
+			"https://[2001:DB8::1]:443/abc",
+			"https://[2001:db8::1]/abc",
+		},
+		{
+			"trailing slashes",
+			"https://example.com/abc//",
+			"https://example.com/abc",
+		},
+		{
+			"root path",
+			"https://example.com/",
+			"https://example.com",
+		},
+		{
+			"fragment",
+			"https://example.com/abc#section",
+			"https://example.com/abc",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := normalizeURL(tt.given, defaultStripParams)
+			if err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("(%+v): expected %+v, got %+v", tt.given, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestNormalizeURLCustomStripParams(t *testing.T) {
+	result, err := normalizeURL("https://example.com/abc?ref=feed&utm_source=feed", []string{"ref"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	expected := "https://example.com/abc?utm_source=feed"
+	if result != expected {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestArchiveNormalizedLinks(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	markdown := " [abc](https://www.example.com/abc/?utm_source=feed)\n [abc](https://example.com/abc?fbclid=xyz)\n"
+	err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := 0
+	a := &Archiver{
+		InputDir:  inputDir,
+		OutputDir: outputDir,
+		Normalize: true,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			fetched++
+			if link != "https://www.example.com/abc/?utm_source=feed" {
+				t.Errorf("expected link to be fetched as written, got %+v", link)
+			}
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if fetched != 1 {
+		t.Errorf("expected 1 fetch, got %d", fetched)
+	}
+	archives, err := filepath.Glob(filepath.Join(outputDir, "*", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 1 {
+		t.Fatalf("expected 1 archive, got %+v", archives)
+	}
+	metadata, err := readMetadata(archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.URL != "https://www.example.com/abc/?utm_source=feed" {
+		t.Errorf("expected link as written in metadata, got %+v", metadata.URL)
+	}
+}
+
+func TestArchiveNormalizedLinkAgain(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [a](https://www.example.com/a?utm_source=x)\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := 0
+	for i := 0; i < 3; i++ {
+		a := &Archiver{
+			InputDir:  inputDir,
+			OutputDir: outputDir,
+			Normalize: true,
+			Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+				fetched++
+				return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+			}),
+		}
+		if err := a.Archive(); err != nil {
+			t.Fatalf("expected nil error, got %+v", err)
+		}
+	}
+	// the archive of the first run isn't mistaken for a collision
+	if fetched != 1 {
+		t.Errorf("expected 1 fetch, got %d", fetched)
+	}
+	archives, err := filepath.Glob(filepath.Join(outputDir, "*", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 1 {
+		t.Errorf("expected 1 archive, got %+v", archives)
+	}
+}
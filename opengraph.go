@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/go-shiori/go-readability"
+	"golang.org/x/net/html"
+)
+
+// pageMetadata is metadata declared by a page in OpenGraph <meta> tags or
+// JSON-LD blocks.
+type pageMetadata struct {
+	Title         string
+	Description   string
+	Image         string
+	Author        string
+	PublishedTime *time.Time
+}
+
+// applyPageMetadata overrides the metadata readability guessed for article
+// with the metadata declared by the page, which is more reliable. JSON-LD is
+// preferred over OpenGraph, since it is usually more specific to the article.
+func applyPageMetadata(article *readability.Article, page string) {
+	og, ld := extractPageMetadata(page)
+	for _, m := range []pageMetadata{og, ld} {
+		if m.Title != "" {
+			article.Title = m.Title
+		}
+		if m.Description != "" {
+			article.Excerpt = m.Description
+		}
+		if m.Image != "" {
+			article.Image = m.Image
+		}
+		if m.Author != "" {
+			article.Byline = m.Author
+		}
+		if m.PublishedTime != nil {
+			article.PublishedTime = m.PublishedTime
+		}
+	}
+}
+
+// extractPageMetadata returns the metadata declared by page in OpenGraph
+// <meta> tags, and in JSON-LD blocks.
+func extractPageMetadata(page string) (og pageMetadata, ld pageMetadata) {
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		return og, ld
+	}
+	var description string
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "meta":
+				key := strings.ToLower(attrValue(n, "property"))
+				if key == "" {
+					key = strings.ToLower(attrValue(n, "name"))
+				}
+				content := strings.TrimSpace(attrValue(n, "content"))
+				switch key {
+				case "og:title":
+					og.Title = content
+				case "og:description":
+					og.Description = content
+				case "description":
+					description = content
+				case "og:image":
+					og.Image = content
+				case "article:author":
+					og.Author = content
+				case "article:published_time":
+					og.PublishedTime = parsePageTime(content)
+				}
+			case "script":
+				if strings.EqualFold(attrValue(n, "type"), "application/ld+json") && n.FirstChild != nil && ld == (pageMetadata{}) {
+					ld = parseJSONLD(n.FirstChild.Data)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(doc)
+	if og.Description == "" {
+		og.Description = description
+	}
+	return og, ld
+}
+
+// parseJSONLD returns the metadata of the first article, or failing that the
+// first object, described by a JSON-LD block.
+func parseJSONLD(block string) pageMetadata {
+	var v interface{}
+	if err := json.Unmarshal([]byte(block), &v); err != nil {
+		return pageMetadata{}
+	}
+	var objects []map[string]interface{}
+	var collect func(v interface{})
+	collect = func(v interface{}) {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, item := range v {
+				collect(item)
+			}
+		case map[string]interface{}:
+			if graph, ok := v["@graph"]; ok {
+				collect(graph)
+			} else {
+				objects = append(objects, v)
+			}
+		}
+	}
+	collect(v)
+	if len(objects) == 0 {
+		return pageMetadata{}
+	}
+	object := objects[0]
+	for _, o := range objects {
+		if isArticleType(o["@type"]) {
+			object = o
+			break
+		}
+	}
+
+	m := pageMetadata{
+		Title:       jsonLDString(object["headline"]),
+		Description: jsonLDString(object["description"]),
+		Image:       jsonLDString(object["image"]),
+		Author:      jsonLDString(object["author"]),
+	}
+	if m.Title == "" {
+		m.Title = jsonLDString(object["name"])
+	}
+	m.PublishedTime = parsePageTime(jsonLDString(object["datePublished"]))
+	return m
+}
+
+// isArticleType reports whether a JSON-LD @type is a kind of article, such as
+// Article, NewsArticle or BlogPosting.
+func isArticleType(v interface{}) bool {
+	switch v := v.(type) {
+	case string:
+		return strings.HasSuffix(v, "Article") || v == "BlogPosting"
+	case []interface{}:
+		for _, t := range v {
+			if isArticleType(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonLDString returns a JSON-LD value as a string. Objects such as an
+// ImageObject or Person are represented by their url or name, and lists by
+// their first item.
+func jsonLDString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case []interface{}:
+		if len(v) > 0 {
+			return jsonLDString(v[0])
+		}
+	case map[string]interface{}:
+		if s := jsonLDString(v["url"]); s != "" {
+			return s
+		}
+		return jsonLDString(v["name"])
+	}
+	return ""
+}
+
+// parsePageTime parses a date or date and time declared by a page.
+func parsePageTime(s string) *time.Time {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// attrValue returns the value of the attribute of n named key.
+func attrValue(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
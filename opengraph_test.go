@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/go-readability"
+)
+
+const testOpenGraphHead = `<meta property="og:title" content="OpenGraph Title">
+<meta property="og:description" content="OpenGraph description">
+<meta property="og:image" content="https://example.com/og.png">
+<meta property="article:published_time" content="2024-01-02T03:04:05Z">
+<meta name="description" content="Meta description">`
+
+const testJSONLDHead = `<script type="application/ld+json">
+{"@context": "https://schema.org", "@graph": [
+	{"@type": "WebSite", "name": "Example Site"},
+	{"@type": ["NewsArticle"], "headline": "JSON-LD Headline", "datePublished": "2024-02-03",
+	 "image": {"@type": "ImageObject", "url": "https://example.com/ld.png"},
+	 "author": [{"@type": "Person", "name": "Jane Doe"}]}
+]}
+</script>`
+
+func TestApplyPageMetadata(t *testing.T) {
+	ogTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ldTime := time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)
+	readabilityTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		name     string
+		head     string
+		expected readability.Article
+	}{
+		{
+			"no page metadata",
+			"",
+			readability.Article{Title: "Readability Title", Excerpt: "Readability excerpt", PublishedTime: &readabilityTime},
+		},
+		{
+			"opengraph",
+			testOpenGraphHead,
+			readability.Article{Title: "OpenGraph Title", Excerpt: "OpenGraph description", Image: "https://example.com/og.png", PublishedTime: &ogTime},
+		},
+		{
+			"json-ld over opengraph",
+			testOpenGraphHead + testJSONLDHead,
+			readability.Article{Title: "JSON-LD Headline", Byline: "Jane Doe", Excerpt: "OpenGraph description", Image: "https://example.com/ld.png", PublishedTime: &ldTime},
+		},
+		{
+			"meta description",
+			`<meta name="description" content="Meta description">`,
+			readability.Article{Title: "Readability Title", Excerpt: "Meta description", PublishedTime: &readabilityTime},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			article := readability.Article{Title: "Readability Title", Excerpt: "Readability excerpt", PublishedTime: &readabilityTime}
+			applyPageMetadata(&article, "<html><head>"+tt.head+"</head><body></body></html>")
+			if article.Title != tt.expected.Title {
+				t.Errorf("expected title %+v, got %+v", tt.expected.Title, article.Title)
+			}
+			if article.Excerpt != tt.expected.Excerpt {
+				t.Errorf("expected excerpt %+v, got %+v", tt.expected.Excerpt, article.Excerpt)
+			}
+			if article.Image != tt.expected.Image {
+				t.Errorf("expected image %+v, got %+v", tt.expected.Image, article.Image)
+			}
+			if article.Byline != tt.expected.Byline {
+				t.Errorf("expected byline %+v, got %+v", tt.expected.Byline, article.Byline)
+			}
+			if !article.PublishedTime.Equal(*tt.expected.PublishedTime) {
+				t.Errorf("expected published time %+v, got %+v", tt.expected.PublishedTime, article.PublishedTime)
+			}
+		})
+	}
+}
+
+func TestFetchFromURLPageMetadata(t *testing.T) {
+	page := strings.Replace(testArticleHTML, "<head>", "<head>"+testOpenGraphHead+testJSONLDHead, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	a := &Archiver{AllowPrivateIPs: true}
+	article, err := a.fetchFromURL(context.Background(), server.URL, cacheValidators{})
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if article.Title != "JSON-LD Headline" {
+		t.Errorf("expected title %q, got %q", "JSON-LD Headline", article.Title)
+	}
+	if article.Image != "https://example.com/ld.png" {
+		t.Errorf("expected image %q, got %q", "https://example.com/ld.png", article.Image)
+	}
+}
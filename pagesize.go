@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// tooLargeLinksFile lists the links, one per line, whose captures exceeded
+// MaxPageBytes and were not archived.
+const tooLargeLinksFile = ".too_large_links.txt"
+
+// ErrPageTooLarge is returned when a capture exceeds MaxPageBytes.
+var ErrPageTooLarge = errors.New("captured page is too large")
+
+// checkPageSize returns ErrPageTooLarge if content, the contents of an
+// archived file, exceeds MaxPageBytes.
+func (a *Archiver) checkPageSize(content string) error {
+	if a.MaxPageBytes > 0 && int64(len(content)) > a.MaxPageBytes {
+		return fmt.Errorf("%w: %d bytes is over the limit of %d bytes", ErrPageTooLarge, len(content), a.MaxPageBytes)
+	}
+	return nil
+}
+
+func (a *Archiver) initTooLargeLinks() error {
+	a.tooLargeLinks = make(map[string]bool)
+	b, err := os.ReadFile(path.Join(a.OutputDir, tooLargeLinksFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, link := range strings.Fields(string(b)) {
+		a.tooLargeLinks[link] = true
+	}
+	return nil
+}
+
+// setTooLarge records whether the capture of link was too large to archive.
+func (a *Archiver) setTooLarge(link string, tooLarge bool) {
+	if a.tooLargeLinks == nil {
+		return
+	}
+	if tooLarge {
+		a.tooLargeLinks[link] = true
+	} else {
+		delete(a.tooLargeLinks, link)
+	}
+}
+
+// writeTooLargeLinks writes the list of links that were too large to archive,
+// removing it once there are none.
+func (a *Archiver) writeTooLargeLinks() error {
+	filePath := path.Join(a.OutputDir, tooLargeLinksFile)
+	if len(a.tooLargeLinks) == 0 {
+		err := os.Remove(filePath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	links := make([]string, 0, len(a.tooLargeLinks))
+	for link := range a.tooLargeLinks {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+	return os.WriteFile(filePath, []byte(strings.Join(links, "\n")+"\n"), 0644)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestArchiveMaxPageBytes(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	markdown := " [small](https://example.com/small)\n [huge](https://example.com/huge)\n"
+	if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var failed []error
+	a := &Archiver{
+		InputDir:     inputDir,
+		OutputDir:    outputDir,
+		MaxPageBytes: 1000,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			content := "<p>abc</p>"
+			if link == "https://example.com/huge" {
+				content = "<p>" + strings.Repeat("abc ", 1000) + "</p>"
+			}
+			return readability.Article{Title: "Example", Content: content}, nil
+		}),
+		OnError: func(url string, err error) {
+			failed = append(failed, err)
+		},
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if len(failed) != 1 || !errors.Is(failed[0], ErrPageTooLarge) {
+		t.Errorf("expected error %+v, got %+v", ErrPageTooLarge, failed)
+	}
+	for link, expected := range map[string]bool{"https://example.com/small": true, "https://example.com/huge": false} {
+		linkID, err := getLinkID(link, defaultMaxIDLength, defaultHashLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = os.Stat(filepath.Join(outputDir, linkID))
+		if archived := err == nil; archived != expected {
+			t.Errorf("expected %s archived to be %t, got %+v", link, expected, err)
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(outputDir, tooLargeLinksFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "https://example.com/huge\n" {
+		t.Errorf("expected too large link to be listed, got %q", b)
+	}
+
+	// once the limit is lifted, refreshing archives the page and removes it
+	// from the list
+	a.MaxPageBytes = 0
+	a.Refresh = true
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, tooLargeLinksFile)); !os.IsNotExist(err) {
+		t.Errorf("expected empty too large list to be removed, got %+v", err)
+	}
+}
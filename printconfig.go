@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// redacted replaces secret values in the printed configuration.
+const redacted = "[redacted]"
+
+// secretHeaderWords are the words in the names of headers whose values are
+// redacted from the printed configuration.
+var secretHeaderWords = []string{"auth", "cookie", "token", "key", "secret", "session", "password"}
+
+// effectiveConfig is the configuration of a run, as printed by -print-config.
+type effectiveConfig struct {
+	// Flags are the values of every flag, whether set or defaulted.
+	Flags yaml.MapSlice `yaml:"flags"`
+	// Headers and DomainRules are read from -headers-file and
+	// -domain-rules.
+	Headers     HostHeaders `yaml:"headers,omitempty"`
+	DomainRules DomainRules `yaml:"domain_rules,omitempty"`
+}
+
+// printConfig writes the configuration of a run with the flags of fs, and the
+// headers and domain rules read from files, to w as YAML. Header values that
+// look like credentials, and passwords in URLs, are redacted.
+func printConfig(w io.Writer, fs *flag.FlagSet, headers HostHeaders, rules DomainRules) error {
+	config := effectiveConfig{
+		Headers:     redactHostHeaders(headers),
+		DomainRules: redactDomainRules(rules),
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "print-config" {
+			return
+		}
+		config.Flags = append(config.Flags, yaml.MapItem{Key: f.Name, Value: flagValue(f)})
+	})
+	b, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// flagValue returns the value of f, typed as it was parsed so that it is
+// encoded as a YAML number or boolean where it is one.
+func flagValue(f *flag.Flag) interface{} {
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return redactURL(f.Value.String())
+	}
+	switch v := getter.Get().(type) {
+	case time.Duration:
+		return v.String()
+	case string:
+		return redactURL(v)
+	default:
+		return v
+	}
+}
+
+// redactURL redacts the password of s, if it is a URL with one, such as a
+// proxy URL.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	return u.Redacted()
+}
+
+// redactHeaders returns a copy of headers with the values of secret headers
+// redacted.
+func redactHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	redactedHeaders := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if isSecretHeader(name) {
+			value = redacted
+		}
+		redactedHeaders[name] = value
+	}
+	return redactedHeaders
+}
+
+func redactHostHeaders(headers HostHeaders) HostHeaders {
+	if headers == nil {
+		return nil
+	}
+	redactedHeaders := make(HostHeaders, len(headers))
+	for domain, h := range headers {
+		redactedHeaders[domain] = redactHeaders(h)
+	}
+	return redactedHeaders
+}
+
+func redactDomainRules(rules DomainRules) DomainRules {
+	if rules == nil {
+		return nil
+	}
+	redactedRules := make(DomainRules, len(rules))
+	for domain, rule := range rules {
+		rule.Headers = redactHeaders(rule.Headers)
+		redactedRules[domain] = rule
+	}
+	return redactedRules
+}
+
+// isSecretHeader reports whether the value of the header named name is likely
+// to be a credential.
+func isSecretHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range secretHeaderWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Prune finds archives in the output directory that no longer correspond to a
+// link in any markdown file in the input directory, and returns their link
+// IDs. When deleteOrphaned is true the archives are removed, along with any entries in
+// the checked link cache for links that no longer appear in the input.
+func (a *Archiver) Prune(deleteOrphaned bool) ([]string, error) {
+	if a.InputDir == stdinInput {
+		return nil, errors.New("cannot prune when reading markdown from stdin")
+	}
+	// archives of links in files outside the glob would be orphaned
+	if a.InputGlob != "" {
+		return nil, errors.New("cannot prune when the input is a glob")
+	}
+	if a.Bundle != "" {
+		return nil, errors.New("cannot prune a bundle")
+	}
+	if deleteOrphaned {
+		release, err := a.acquireLock(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+	liveLinks := make(map[string]bool)
+	liveLinkIDs := make(map[string]bool)
+	a.ignoreCache = nil
+	err := a.walkInputFiles(func(filePath string, info os.FileInfo) error {
+		links, err := a.readLinksInFile(filePath)
+		if err != nil {
+			return err
+		}
+		links, err = a.filterIgnored(filePath, links)
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			link, err := a.normalizeLink(l.URL)
+			if err != nil {
+				a.logf(logEvent{Event: eventWarning, URL: link, SourceFile: a.relativeInputPath(filePath), Line: l.Line, Err: err}, "cannot normalize link %+v (%s:%d): %+v", link, filePath, l.Line, err)
+			}
+			linkID, err := a.linkID(link)
+			if err != nil {
+				a.logf(logEvent{Event: eventWarning, URL: link, SourceFile: a.relativeInputPath(filePath), Line: l.Line, Err: err}, "cannot get link ID for %+v (%s:%d): %v", link, filePath, l.Line, err)
+				continue
+			}
+			liveLinks[link] = true
+			liveLinkIDs[linkID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	archives, err := a.listArchives()
+	if err != nil {
+		return nil, err
+	}
+	var orphaned []string
+	for linkID, entryPath := range archives {
+		if liveLinkIDs[linkID] {
+			continue
+		}
+		// archives under a canonical URL are kept while the link they
+		// were requested for is
+		isDir := entryPath != archivePath(a.OutputDir, linkID, formatSingleFile)
+		_, metadata, err := archiveFile(a.OutputDir, linkID, isDir)
+		// requested links are recorded as written, so they are normalized
+		// like the links in the input
+		requested, _ := a.normalizeLink(metadata.RequestedURL)
+		if err == nil && liveLinks[requested] {
+			liveLinkIDs[linkID] = true
+			continue
+		}
+		orphaned = append(orphaned, linkID)
+		if deleteOrphaned {
+			if err := os.RemoveAll(entryPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Strings(orphaned)
+	if !deleteOrphaned {
+		return orphaned, nil
+	}
+
+	err = a.initCheckedLinkCache()
+	if err != nil {
+		return nil, err
+	}
+	err = a.initLastCheckedCache()
+	if err != nil {
+		return nil, err
+	}
+	for linkID := range a.checkedLinks {
+		if !liveLinkIDs[linkID] {
+			delete(a.checkedLinks, linkID)
+		}
+	}
+	for linkID := range a.lastChecked {
+		if !liveLinkIDs[linkID] {
+			delete(a.lastChecked, linkID)
+		}
+	}
+	err = a.writeCheckedLinkCache()
+	if err != nil {
+		return nil, err
+	}
+	err = a.writeLastCheckedCache()
+	if err != nil {
+		return nil, err
+	}
+	return orphaned, nil
+}
+
+// listArchives returns the archives in the output directory, mapping each
+// link ID to the path that holds its archive. Only entries with readable
+// archive metadata are returned, so unrelated files in the output directory
+// are never mistaken for archives.
+func (a *Archiver) listArchives() (map[string]string, error) {
+	entries, err := os.ReadDir(a.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+	archives := make(map[string]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		entryPath := path.Join(a.OutputDir, name)
+		linkID := name
+		if !entry.IsDir() {
+			linkID = strings.TrimSuffix(name, ".html")
+			if linkID == name {
+				continue
+			}
+		}
+		if _, _, err := archiveFile(a.OutputDir, linkID, entry.IsDir()); err == nil {
+			archives[linkID] = entryPath
+		}
+	}
+	return archives, nil
+}
+
+// archiveFile returns the path and metadata of the archived file for linkID.
+// isDir reports whether the archive is held in a directory per link, rather
+// than as a single file.
+func archiveFile(outputDir, linkID string, isDir bool) (string, Metadata, error) {
+	var filePaths []string
+	if isDir {
+		// prefer the latest snapshot, in case the archive was written both
+		// with and without history
+		for _, format := range []string{formatHTML, formatMarkdown} {
+			fileName := path.Base(archivePath(outputDir, linkID, format))
+			filePaths = append(filePaths, path.Join(outputDir, linkID, latestSnapshot, fileName))
+		}
+		filePaths = append(filePaths, archivePath(outputDir, linkID, formatHTML), archivePath(outputDir, linkID, formatMarkdown))
+	} else {
+		filePaths = []string{archivePath(outputDir, linkID, formatSingleFile)}
+	}
+	var err error
+	for _, filePath := range filePaths {
+		var metadata Metadata
+		metadata, err = readMetadata(filePath)
+		if err == nil {
+			return filePath, metadata, nil
+		}
+	}
+	return "", Metadata{}, err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestPrune(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	notesPath := filepath.Join(inputDir, "notes.md")
+	err := os.WriteFile(notesPath, []byte(" [a](https://example.com/a)\n [b](https://example.com/b)\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{
+		InputDir:  inputDir,
+		OutputDir: outputDir,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	liveLinkID, err := getLinkID("https://example.com/a", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphanedLinkID, err := getLinkID("https://example.com/b", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// remove a link from the notes, and add an unrelated directory that
+	// isn't an archive
+	err = os.WriteFile(notesPath, []byte(" [a](https://example.com/a)\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(outputDir, "assets"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// report only
+	a = &Archiver{InputDir: inputDir, OutputDir: outputDir}
+	orphaned, err := a.Prune(false)
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if !reflect.DeepEqual(orphaned, []string{orphanedLinkID}) {
+		t.Errorf("expected %+v to be orphaned, got %+v", []string{orphanedLinkID}, orphaned)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, orphanedLinkID)); err != nil {
+		t.Errorf("expected orphaned archive to be kept when reporting, got %+v", err)
+	}
+
+	// delete
+	a = &Archiver{InputDir: inputDir, OutputDir: outputDir}
+	orphaned, err = a.Prune(true)
+	if err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if !reflect.DeepEqual(orphaned, []string{orphanedLinkID}) {
+		t.Errorf("expected %+v to be pruned, got %+v", []string{orphanedLinkID}, orphaned)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, orphanedLinkID)); !os.IsNotExist(err) {
+		t.Errorf("expected orphaned archive to be deleted, got %+v", err)
+	}
+	for _, dir := range []string{liveLinkID, "assets"} {
+		if _, err := os.Stat(filepath.Join(outputDir, dir)); err != nil {
+			t.Errorf("expected %s to be kept, got %+v", dir, err)
+		}
+	}
+
+	a = &Archiver{OutputDir: outputDir}
+	if err := a.initCheckedLinkCache(); err != nil {
+		t.Fatal(err)
+	}
+	if a.isLinkCheckedBefore(orphanedLinkID) {
+		t.Errorf("expected pruned link to be removed from cache")
+	}
+	if !a.isLinkCheckedBefore(liveLinkID) {
+		t.Errorf("expected live link to be kept in cache")
+	}
+}
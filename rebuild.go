@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RebuildCache regenerates the checked link and last checked caches from the
+// archives in the output directory, replacing their contents, and returns the
+// number of links recovered. This recovers from a lost or corrupted cache
+// without fetching every link again. Links that failed to archive have no
+// archive to recover them from, so they are retried on the next run.
+func (a *Archiver) RebuildCache() (int, error) {
+	if a.Bundle != "" {
+		return 0, errors.New("cannot rebuild the cache of a bundle")
+	}
+	if a.TrustFilesystem {
+		return 0, errors.New("cannot rebuild the cache when trusting the filesystem, which ignores it")
+	}
+	release, err := a.acquireLock(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	archives, err := a.listArchives()
+	if err != nil {
+		return 0, err
+	}
+	a.checkedLinks = make(map[string]bool, len(archives))
+	a.lastChecked = make(map[string]time.Time, len(archives))
+	for linkID, entryPath := range archives {
+		isDir := entryPath != archivePath(a.OutputDir, linkID, formatSingleFile)
+		_, metadata, err := archiveFile(a.OutputDir, linkID, isDir)
+		if err != nil {
+			continue
+		}
+		a.checkedLinks[linkID] = true
+		if !metadata.ArchivedAt.IsZero() {
+			a.lastChecked[linkID] = metadata.ArchivedAt
+		}
+	}
+	err = a.writeCheckedLinkCache()
+	if err != nil {
+		return 0, err
+	}
+	err = a.writeLastCheckedCache()
+	if err != nil {
+		return 0, err
+	}
+	return len(a.checkedLinks), nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRebuildCache(t *testing.T) {
+	for _, format := range []string{formatHTML, formatSingleFile} {
+		format := format
+		t.Run(format, func(t *testing.T) {
+			outputDir := t.TempDir()
+			archivedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			expectedChecked := make(map[string]bool)
+			expectedLastChecked := make(map[string]time.Time)
+			for i, link := range []string{"https://example.com/a", "https://example.com/b"} {
+				linkID, err := getLinkID(link, defaultMaxIDLength, defaultHashLength)
+				if err != nil {
+					t.Fatal(err)
+				}
+				archived := archivedAt.Add(time.Duration(i) * time.Hour)
+				content := "---\nurl: " + link + "\narchived_at: " + archived.Format(time.RFC3339) + "\n---\n<p>abc</p>"
+				if format == formatSingleFile {
+					content = "<!--\n" + strings.Replace(content, "\n---\n<p>", "\n---\n-->\n<p>", 1)
+				}
+				if err := writeArchive(outputDir, archivePath(outputDir, linkID, format), strings.NewReader(content)); err != nil {
+					t.Fatal(err)
+				}
+				expectedChecked[linkID] = true
+				expectedLastChecked[linkID] = archived
+			}
+			// unrelated files in the output directory aren't recovered
+			if err := os.Mkdir(filepath.Join(outputDir, "notes"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(outputDir, ".checked_links.txt"), []byte("corrupted\x00"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// rebuilding is idempotent
+			for i := 0; i < 2; i++ {
+				a := &Archiver{OutputDir: outputDir, Format: format}
+				recovered, err := a.RebuildCache()
+				if err != nil {
+					t.Fatalf("expected nil error, got %+v", err)
+				}
+				if recovered != len(expectedChecked) {
+					t.Errorf("expected %d recovered entries, got %d", len(expectedChecked), recovered)
+				}
+
+				a = &Archiver{OutputDir: outputDir}
+				if err := a.initCheckedLinkCache(); err != nil {
+					t.Fatal(err)
+				}
+				if err := a.initLastCheckedCache(); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(a.checkedLinks, expectedChecked) {
+					t.Errorf("expected checked links %+v, got %+v", expectedChecked, a.checkedLinks)
+				}
+				for linkID, expected := range expectedLastChecked {
+					if !a.lastChecked[linkID].Equal(expected) {
+						t.Errorf("expected %s last checked at %+v, got %+v", linkID, expected, a.lastChecked[linkID])
+					}
+				}
+			}
+		})
+	}
+}
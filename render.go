@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/go-shiori/go-readability"
+)
+
+// renderTimeout is the timeout for rendering a single link in a headless
+// browser.
+const renderTimeout = 30 * time.Second
+
+// Methods used to capture an archived page.
+const (
+	captureMethodFetch    = "fetch"
+	captureMethodRenderJS = "render-js"
+)
+
+// renderArticle renders link in a headless browser and applies Extractor to
+// the resulting DOM.
+func (a *Archiver) renderArticle(ctx context.Context, link string) (readability.Article, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return readability.Article{}, fmt.Errorf("failed to parse URL: %v", err)
+	}
+	var html string
+	if a.renderPage != nil {
+		html, err = a.renderPage(link)
+	} else {
+		// the requests of the browser are also checked, but checking the
+		// host up front reports why the link can't be rendered
+		if !a.AllowPrivateIPs {
+			if err := a.checkHost(ctx, u.Hostname()); err != nil {
+				return readability.Article{}, err
+			}
+		}
+		html, err = a.renderPageWithChrome(ctx, link)
+	}
+	if err != nil {
+		return readability.Article{}, fmt.Errorf("failed to render the page: %v", err)
+	}
+	return a.extract(html, u)
+}
+
+// renderPageWithChrome loads link in a tab of the shared headless browser and
+// returns the HTML of the page after scripts have run.
+func (a *Archiver) renderPageWithChrome(ctx context.Context, link string) (string, error) {
+	ctx, cancel, err := a.newTab(ctx, link)
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+
+	var html string
+	err = chromedp.Run(ctx,
+		chromedp.Navigate(link),
+		chromedp.OuterHTML("html", &html, chromedp.ByQuery),
+	)
+	if err != nil {
+		return "", err
+	}
+	return html, nil
+}
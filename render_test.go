@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestArchiveRenderJSFallback(t *testing.T) {
+	var tests = []struct {
+		name           string
+		fetchContent   string
+		fetchErr       error
+		expectRendered bool
+	}{
+		{"empty capture", "", nil, true},
+		{"failed capture", "", errors.New("the page is not readable"), true},
+		{"successful capture", "<p>fetched</p>", nil, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			outputDir := t.TempDir()
+			err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc](https://example.com)"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rendered := false
+			a := &Archiver{
+				InputDir:  inputDir,
+				OutputDir: outputDir,
+				RenderJS:  true,
+				Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+					return readability.Article{Content: tt.fetchContent}, tt.fetchErr
+				}),
+				renderPage: func(link string) (string, error) {
+					rendered = true
+					return testArticleHTML, nil
+				},
+			}
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			if rendered != tt.expectRendered {
+				t.Errorf("expected rendered to be %v, got %v", tt.expectRendered, rendered)
+			}
+			linkID, err := getLinkID("https://example.com", defaultMaxIDLength, defaultHashLength)
+			if err != nil {
+				t.Fatal(err)
+			}
+			metadata, err := readMetadata(archivePath(outputDir, linkID, formatHTML))
+			if err != nil {
+				t.Fatalf("expected metadata, got %+v", err)
+			}
+			expectedMethod := captureMethodFetch
+			if tt.expectRendered {
+				expectedMethod = captureMethodRenderJS
+			}
+			if metadata.CaptureMethod != expectedMethod {
+				t.Errorf("expected capture method %q, got %q", expectedMethod, metadata.CaptureMethod)
+			}
+		})
+	}
+}
+
+func TestArchiveRenderJSDisabled(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc](https://example.com)"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{
+		InputDir:  inputDir,
+		OutputDir: outputDir,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			return readability.Article{}, nil
+		}),
+		renderPage: func(link string) (string, error) {
+			t.Error("expected page to not be rendered")
+			return "", nil
+		},
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "github.com/microcosm-cc/bluemonday"
+
+// sanitizePolicy is the allowlist of elements and attributes kept in content
+// when sanitizing. It keeps the formatting of articles, such as headings,
+// lists, links, images, tables and code, and removes scripts, styles,
+// embedded content, forms and event handler attributes.
+var sanitizePolicy = newSanitizePolicy()
+
+func newSanitizePolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// links are kept as the page had them, rather than marked nofollow
+	p.RequireNoFollowOnLinks(false)
+	// readability may keep inline images that pages embed as data URIs
+	p.AllowDataURIImages()
+	return p
+}
+
+// sanitizeContent removes the elements and attributes of content that aren't
+// allowed by sanitizePolicy.
+func sanitizeContent(content string) string {
+	return sanitizePolicy.Sanitize(content)
+}
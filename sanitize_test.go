@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestSanitizeContent(t *testing.T) {
+	var tests = []struct {
+		name     string
+		given    string
+		expected string
+	}{
+		{
+			"script",
+			`<p>abc</p><script>alert(1)</script>`,
+			`<p>abc</p>`,
+		},
+		{
+			"event handler",
+			`<p onclick="alert(1)">abc</p><img src="https://example.com/a.png" onerror="alert(1)">`,
+			`<p>abc</p><img src="https://example.com/a.png">`,
+		},
+		{
+			"javascript link",
+			`<a href="javascript:alert(1)">abc</a>`,
+			`abc`,
+		},
+		{
+			"embedded content",
+			`<iframe src="https://example.com"></iframe><style>p { color: red }</style><form><input name="q"></form>`,
+			``,
+		},
+		{
+			"formatting",
+			`<h2>Title</h2><p><a href="https://example.com/a">a</a> <em>b</em></p><pre><code>c</code></pre><ul><li>d</li></ul><table><tr><td>e</td></tr></table>`,
+			`<h2>Title</h2><p><a href="https://example.com/a">a</a> <em>b</em></p><pre><code>c</code></pre><ul><li>d</li></ul><table><tr><td>e</td></tr></table>`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if result := sanitizeContent(tt.given); result != tt.expected {
+				t.Errorf("(%+v): expected %q, got %q", tt.given, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestArchiveSanitize(t *testing.T) {
+	for _, sanitize := range []bool{false, true} {
+		inputDir := t.TempDir()
+		outputDir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc](https://example.com/abc)\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		a := &Archiver{
+			InputDir:  inputDir,
+			OutputDir: outputDir,
+			Sanitize:  sanitize,
+			Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+				return readability.Article{Title: "Example", Content: `<p onclick="alert(1)">abc</p><script>alert(2)</script>`}, nil
+			}),
+			stdout: &bytes.Buffer{},
+		}
+		if err := a.Archive(); err != nil {
+			t.Fatalf("expected nil error, got %+v", err)
+		}
+		linkID, err := getLinkID("https://example.com/abc", defaultMaxIDLength, defaultHashLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(archivePath(outputDir, linkID, formatHTML))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hasScript := strings.Contains(string(b), "<script>") || strings.Contains(string(b), "onclick")
+		if sanitize && hasScript {
+			t.Errorf("expected scripts to be removed, got %s", b)
+		} else if !sanitize && !hasScript {
+			t.Errorf("expected content to be kept as captured, got %s", b)
+		}
+	}
+}
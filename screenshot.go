@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/png"
+	"net/url"
+	"path"
+
+	"github.com/chromedp/chromedp"
+)
+
+// screenshotFile is the name of the screenshot written to link directories
+// when Screenshot is set.
+const screenshotFile = "screenshot.png"
+
+// Viewport of the headless browser when capturing screenshots. The height
+// only affects layout, as the screenshot covers the full page.
+const (
+	defaultScreenshotWidth = 1280
+	screenshotViewHeight   = 800
+)
+
+// saveScreenshot captures a full-page screenshot of link and writes it to
+// the link directory of linkID, returning the dimensions of the image. The
+// screenshot is captured separately from the archived content, so that it is
+// saved even when readability fails on the page.
+func (a *Archiver) saveScreenshot(ctx context.Context, link, linkID string) (width, height int, err error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse URL: %v", err)
+	}
+	viewWidth := a.ScreenshotWidth
+	if viewWidth <= 0 {
+		viewWidth = defaultScreenshotWidth
+	}
+	var b []byte
+	if a.screenshotPage != nil {
+		b, err = a.screenshotPage(link, viewWidth)
+	} else {
+		// the requests of the browser are also checked, but checking the
+		// host up front reports why the link can't be captured
+		if !a.AllowPrivateIPs {
+			if err := a.checkHost(ctx, u.Hostname()); err != nil {
+				return 0, 0, err
+			}
+		}
+		b, err = a.screenshotPageWithChrome(ctx, link, viewWidth)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to capture the page: %v", err)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid screenshot: %v", err)
+	}
+	if format != "png" {
+		return 0, 0, fmt.Errorf("invalid screenshot: expected png, got %s", format)
+	}
+	err = writeArchive(a.OutputDir, path.Join(a.OutputDir, linkID, screenshotFile), bytes.NewReader(b))
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+// screenshotPageWithChrome loads link in a tab of the shared headless browser
+// with a viewport of the given width and returns a PNG screenshot of the full
+// page.
+func (a *Archiver) screenshotPageWithChrome(ctx context.Context, link string, width int) ([]byte, error) {
+	ctx, cancel, err := a.newTab(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	var b []byte
+	err = chromedp.Run(ctx,
+		chromedp.EmulateViewport(int64(width), screenshotViewHeight),
+		chromedp.Navigate(link),
+		// a quality of 100 captures a PNG rather than a JPEG
+		chromedp.FullScreenshot(&b, 100),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// captureScreenshot saves a screenshot of link when Screenshot is set, and
+// returns its dimensions. A screenshot that can't be captured doesn't fail
+// the archive, so it is only logged.
+func (a *Archiver) captureScreenshot(ctx context.Context, link, linkID string, event logEvent) (width, height int) {
+	if !a.Screenshot || a.Format == formatSingleFile || a.bundle != nil {
+		return 0, 0
+	}
+	width, height, err := a.saveScreenshot(ctx, link, linkID)
+	if err != nil {
+		a.logf(event.as(eventWarning, err), "cannot capture screenshot of %+v: %+v", link, err)
+		return 0, 0
+	}
+	return width, height
+}
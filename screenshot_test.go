@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+// testScreenshot returns a PNG of the given width, as tall as a page.
+func testScreenshot(link string, width int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, 2000))); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func TestArchiveScreenshot(t *testing.T) {
+	var tests = []struct {
+		name       string
+		fetchErr   error
+		screenshot func(link string, width int) ([]byte, error)
+		expected   bool
+	}{
+		{
+			"archived",
+			nil,
+			testScreenshot,
+			true,
+		},
+		{
+			"readability fails",
+			errors.New("cannot parse"),
+			testScreenshot,
+			true,
+		},
+		{
+			"screenshot fails",
+			nil,
+			func(link string, width int) ([]byte, error) {
+				return nil, errors.New("cannot capture")
+			},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			outputDir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc](https://example.com/abc)\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var captured string
+			a := &Archiver{
+				InputDir:        inputDir,
+				OutputDir:       outputDir,
+				Screenshot:      true,
+				ScreenshotWidth: 800,
+				Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+					if tt.fetchErr != nil {
+						return readability.Article{}, tt.fetchErr
+					}
+					return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+				}),
+				screenshotPage: func(link string, width int) ([]byte, error) {
+					captured = link
+					return tt.screenshot(link, width)
+				},
+				stdout: &bytes.Buffer{},
+				stderr: &bytes.Buffer{},
+			}
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			if captured != "https://example.com/abc" {
+				t.Errorf("expected screenshot of link, got %+v", captured)
+			}
+			linkID, err := getLinkID("https://example.com/abc", defaultMaxIDLength, defaultHashLength)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(filepath.Join(outputDir, linkID, screenshotFile))
+			if !tt.expected {
+				if err == nil {
+					f.Close()
+					t.Errorf("expected no screenshot")
+				}
+			} else if err != nil {
+				t.Fatalf("expected screenshot, got %+v", err)
+			} else {
+				defer f.Close()
+				config, err := png.DecodeConfig(f)
+				if err != nil {
+					t.Fatalf("expected PNG screenshot, got %+v", err)
+				}
+				if config.Width != 800 || config.Height != 2000 {
+					t.Errorf("expected 800x2000 screenshot, got %dx%d", config.Width, config.Height)
+				}
+			}
+
+			metadata, err := readMetadata(archivePath(outputDir, linkID, formatHTML))
+			if tt.fetchErr != nil {
+				if err == nil {
+					t.Errorf("expected no archive, got %+v", metadata)
+				}
+				// a screenshot alone isn't an archive of the link
+				if a.hasLinkArchive(linkID) {
+					t.Errorf("expected link directory with only a screenshot to not be an archive")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedWidth, expectedHeight := 0, 0
+			if tt.expected {
+				expectedWidth, expectedHeight = 800, 2000
+			}
+			if metadata.ScreenshotWidth != expectedWidth || metadata.ScreenshotHeight != expectedHeight {
+				t.Errorf("expected screenshot dimensions %dx%d in metadata, got %dx%d", expectedWidth, expectedHeight, metadata.ScreenshotWidth, metadata.ScreenshotHeight)
+			}
+		})
+	}
+}
+
+func TestArchiveScreenshotOnlyWhenArchived(t *testing.T) {
+	var tests = []struct {
+		name             string
+		minContentLength int
+		maxPageBytes     int64
+		refresh          bool
+		expectedCaptures int
+	}{
+		{"unchanged on refresh", 0, 0, true, 1},
+		{"content too short", 1000, 0, false, 0},
+		{"page too large", 0, 100, false, 0},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			outputDir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(" [abc](https://example.com/abc)\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			captures := 0
+			runs := 1
+			if tt.refresh {
+				runs = 2
+			}
+			for i := 0; i < runs; i++ {
+				a := &Archiver{
+					InputDir:         inputDir,
+					OutputDir:        outputDir,
+					Screenshot:       true,
+					Refresh:          i > 0,
+					MinContentLength: tt.minContentLength,
+					MaxPageBytes:     tt.maxPageBytes,
+					Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+						return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+					}),
+					screenshotPage: func(link string, width int) ([]byte, error) {
+						captures++
+						return testScreenshot(link, width)
+					},
+					stdout: &bytes.Buffer{},
+					stderr: &bytes.Buffer{},
+				}
+				if err := a.Archive(); err != nil {
+					t.Fatalf("expected nil error, got %+v", err)
+				}
+			}
+			if captures != tt.expectedCaptures {
+				t.Errorf("expected %d screenshots, got %d", tt.expectedCaptures, captures)
+			}
+			if tt.expectedCaptures == 0 {
+				linkID, err := getLinkID("https://example.com/abc", defaultMaxIDLength, defaultHashLength)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := os.Stat(filepath.Join(outputDir, linkID)); !os.IsNotExist(err) {
+					t.Errorf("expected no link directory for a rejected page, got %+v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// serveSource is the source file recorded for links archived through the HTTP
+// API.
+const serveSource = "<api>"
+
+// Limits of the HTTP API.
+const (
+	maxServeRequestBytes = 64 << 10
+	serveShutdownTimeout = 10 * time.Second
+)
+
+// Statuses of a link archived through the HTTP API.
+const (
+	serveStatusArchived = "archived"
+	serveStatusSkipped  = "skipped"
+	serveStatusFailed   = "failed"
+)
+
+// archiveRequest is the body of POST /archive.
+type archiveRequest struct {
+	URL string `json:"url"`
+	// Refresh archives the link again even if it was archived before.
+	Refresh bool `json:"refresh"`
+}
+
+// archiveResponse is the response to POST /archive.
+type archiveResponse struct {
+	Status string `json:"status"`
+	LinkID string `json:"link_id,omitempty"`
+	// Metadata is the frontmatter of the link's archive, with the same
+	// field names.
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+// statusResponse is the response to GET /status.
+type statusResponse struct {
+	ServingSince    time.Time `json:"serving_since"`
+	Archived        int       `json:"archived"`
+	Failed          int       `json:"failed"`
+	Fetches         int       `json:"fetches"`
+	FetchDurationMS int64     `json:"fetch_duration_ms"`
+	CheckedLinks    int       `json:"checked_links"`
+}
+
+// Serve serves an HTTP API on addr that archives links on request into the
+// output directory, until ctx is done. The caches are shared with archiving
+// runs, and are written after each request. The output directory is locked
+// while serving.
+//
+//   - POST /archive with a JSON body of {"url": "..."} archives the link, and
+//     responds with its link ID and archive metadata
+//   - GET /status responds with the statistics of the links archived since
+//     the API started
+func (a *Archiver) Serve(ctx context.Context, addr string) error {
+	if a.Bundle != "" {
+		return errors.New("cannot serve when writing a bundle")
+	}
+	release, err := a.acquireLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer a.closeBrowser()
+	err = a.initCaches()
+	if err != nil {
+		return err
+	}
+	a.metrics = runMetrics{}
+	a.servingSince = time.Now()
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           a.serveHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.ListenAndServe()
+	}()
+	a.logf(logEvent{Event: eventServing}, "Serving on %s", addr)
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	// let requests in progress finish, so that their archives and the
+	// caches are written
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// serveHandler returns the handler of the HTTP API.
+func (a *Archiver) serveHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /archive", a.handleArchive)
+	mux.HandleFunc("GET /status", a.handleStatus)
+	return mux
+}
+
+func (a *Archiver) handleArchive(w http.ResponseWriter, r *http.Request) {
+	var req archiveRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxServeRequestBytes)).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, archiveResponse{Status: serveStatusFailed, Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	if !isHTTPURL(req.URL) {
+		writeJSON(w, http.StatusBadRequest, archiveResponse{Status: serveStatusFailed, Error: fmt.Sprintf("invalid url %q", req.URL)})
+		return
+	}
+
+	a.serveMu.Lock()
+	defer a.serveMu.Unlock()
+	// each request is a run of a single link
+	a.processedLinks = make(map[string]bool)
+	a.newLinks = 0
+	refresh := a.Refresh
+	a.Refresh = refresh || req.Refresh
+	result, err := a.archiveLink(r.Context(), serveSource, markdownLink{URL: req.URL})
+	a.Refresh = refresh
+	if err == nil {
+		err = a.writeCaches()
+	}
+	if err == nil && a.MetricsFile != "" {
+		err = a.writeMetrics(time.Now())
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, archiveResponse{Status: serveStatusFailed, LinkID: result.LinkID, Error: err.Error()})
+		return
+	}
+
+	resp := archiveResponse{LinkID: result.LinkID}
+	status := http.StatusOK
+	metadata := result.Metadata
+	switch result.Event {
+	case eventArchived:
+		resp.Status = serveStatusArchived
+	case eventSkipped:
+		resp.Status = serveStatusSkipped
+		// respond with the existing archive, if the link has one
+		if result.LinkID != "" {
+			if existing, err := readMetadata(a.currentArchivePath(result.LinkID)); err == nil {
+				metadata = existing
+			}
+		}
+	default:
+		resp.Status = serveStatusFailed
+		resp.Error = result.Err.Error()
+		status = http.StatusBadGateway
+	}
+	if metadata.URL != "" {
+		resp.Metadata, err = metadataFields(metadata)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, archiveResponse{Status: serveStatusFailed, LinkID: result.LinkID, Error: err.Error()})
+			return
+		}
+	}
+	writeJSON(w, status, resp)
+}
+
+func (a *Archiver) handleStatus(w http.ResponseWriter, r *http.Request) {
+	a.serveMu.Lock()
+	checkedLinks := len(a.checkedLinks)
+	a.serveMu.Unlock()
+
+	m := &a.metrics
+	failed := m.failureCount()
+	m.mu.Lock()
+	resp := statusResponse{
+		ServingSince:    a.servingSince,
+		Archived:        m.archived,
+		Failed:          failed,
+		Fetches:         m.fetches,
+		FetchDurationMS: m.fetchDuration.Milliseconds(),
+		CheckedLinks:    checkedLinks,
+	}
+	m.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// metadataFields returns metadata keyed by its frontmatter field names, so
+// that API responses match the archived files.
+func metadataFields(metadata Metadata) (map[string]interface{}, error) {
+	b, err := yaml.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := yaml.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// writeJSON writes v as the JSON body of a response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestServeArchive(t *testing.T) {
+	outputDir := t.TempDir()
+	fetched := 0
+	a := &Archiver{
+		OutputDir: outputDir,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			if strings.HasSuffix(link, "/broken") {
+				return readability.Article{}, errors.New("cannot parse")
+			}
+			// change the content so that refreshing rewrites the archive
+			fetched++
+			return readability.Article{Title: "Example", Content: fmt.Sprintf("<p>abc %d</p>", fetched)}, nil
+		}),
+		stdout: &bytes.Buffer{},
+		stderr: &bytes.Buffer{},
+	}
+	if err := a.initCaches(); err != nil {
+		t.Fatal(err)
+	}
+	handler := a.serveHandler()
+	linkID, err := getLinkID("https://example.com/abc", defaultMaxIDLength, defaultHashLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name           string
+		body           string
+		expectedCode   int
+		expectedStatus string
+		expectedLinkID string
+		expectedTitle  string
+	}{
+		{"archived", `{"url": "https://example.com/abc"}`, http.StatusOK, serveStatusArchived, linkID, "Example"},
+		{"archived before", `{"url": "https://example.com/abc"}`, http.StatusOK, serveStatusSkipped, linkID, "Example"},
+		{"refresh", `{"url": "https://example.com/abc", "refresh": true}`, http.StatusOK, serveStatusArchived, linkID, "Example"},
+		{"fetch fails", `{"url": "https://example.com/broken"}`, http.StatusBadGateway, serveStatusFailed, "", ""},
+		{"invalid url", `{"url": "example.com"}`, http.StatusBadRequest, serveStatusFailed, "", ""},
+		{"invalid body", `https://example.com`, http.StatusBadRequest, serveStatusFailed, "", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/archive", strings.NewReader(tt.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.expectedCode {
+			t.Errorf("%s: expected status code %d, got %d: %s", tt.name, tt.expectedCode, rec.Code, rec.Body.String())
+		}
+		var resp archiveResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: expected JSON response, got %+v", tt.name, err)
+		}
+		if resp.Status != tt.expectedStatus {
+			t.Errorf("%s: expected status %+v, got %+v", tt.name, tt.expectedStatus, resp.Status)
+		}
+		if tt.expectedLinkID != "" && resp.LinkID != tt.expectedLinkID {
+			t.Errorf("%s: expected link ID %+v, got %+v", tt.name, tt.expectedLinkID, resp.LinkID)
+		}
+		if title, _ := resp.Metadata["title"].(string); title != tt.expectedTitle {
+			t.Errorf("%s: expected title %+v in metadata, got %+v", tt.name, tt.expectedTitle, resp.Metadata)
+		}
+		if tt.expectedStatus == serveStatusFailed && resp.Error == "" {
+			t.Errorf("%s: expected error in response", tt.name)
+		}
+	}
+
+	// archived links are recorded in the same caches as archiving runs
+	b, err := os.ReadFile(path.Join(outputDir, checkedLinksFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), linkID) {
+		t.Errorf("expected %s in checked link cache, got %q", linkID, string(b))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+	var status statusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("expected JSON response, got %+v", err)
+	}
+	if status.Archived != 2 || status.Failed != 1 || status.Fetches != 3 {
+		t.Errorf("expected 2 archived, 1 failed and 3 fetches, got %+v", status)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/archive", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status code %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &Archiver{
+		OutputDir: t.TempDir(),
+		stdout:    &bytes.Buffer{},
+		stderr:    &bytes.Buffer{},
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- a.Serve(ctx, "127.0.0.1:0")
+	}()
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %+v, got %+v", context.Canceled, err)
+	}
+	if _, err := os.Stat(path.Join(a.OutputDir, lockFile)); !os.IsNotExist(err) {
+		t.Errorf("expected lock to be released, got %+v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// initSharedCaches reads the checked link caches in SharedCaches. Each is
+// either a cache file or an output directory holding one, and caches that
+// don't exist yet are treated as empty.
+func (a *Archiver) initSharedCaches() error {
+	a.sharedCheckedLinks = make(map[string]bool)
+	if a.TrustFilesystem {
+		return nil
+	}
+	for _, cachePath := range a.SharedCaches {
+		if info, err := os.Stat(cachePath); err == nil && info.IsDir() {
+			cachePath = filepath.Join(cachePath, checkedLinksFile)
+		}
+		b, err := os.ReadFile(cachePath)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, linkID := range strings.Fields(string(b)) {
+			a.sharedCheckedLinks[linkID] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestArchiveSharedCaches(t *testing.T) {
+	linkIDs := make(map[string]string)
+	for _, name := range []string{"local", "shared-file", "shared-dir", "new"} {
+		linkID, err := getLinkID("https://example.com/"+name, defaultMaxIDLength, defaultHashLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		linkIDs[name] = linkID
+	}
+	sharedFile := filepath.Join(t.TempDir(), "checked.txt")
+	sharedDir := t.TempDir()
+	caches := map[string]string{
+		sharedFile: linkIDs["shared-file"],
+		filepath.Join(sharedDir, checkedLinksFile): linkIDs["shared-dir"],
+	}
+	for cachePath, linkID := range caches {
+		if err := os.WriteFile(cachePath, []byte(linkID), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		name            string
+		refresh         bool
+		trustFilesystem bool
+		expectedFetched []string
+	}{
+		// links in the local or any shared cache are skipped
+		{"shared caches", false, false, []string{"new"}},
+		// refreshing and trusting the filesystem ignore all caches
+		{"refresh", true, false, []string{"local", "new", "shared-dir", "shared-file"}},
+		{"trust filesystem", false, true, []string{"local", "new", "shared-dir", "shared-file"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			outputDir := t.TempDir()
+			var markdown string
+			for name := range linkIDs {
+				markdown += " [" + name + "](https://example.com/" + name + ")\n"
+			}
+			if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(outputDir, checkedLinksFile), []byte(linkIDs["local"]), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var fetched []string
+			a := &Archiver{
+				InputDir:        inputDir,
+				OutputDir:       outputDir,
+				Refresh:         tt.refresh,
+				TrustFilesystem: tt.trustFilesystem,
+				SharedCaches:    []string{sharedFile, sharedDir, filepath.Join(t.TempDir(), "missing.txt")},
+				Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+					fetched = append(fetched, strings.TrimPrefix(link, "https://example.com/"))
+					return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+				}),
+			}
+			if err := a.Archive(); err != nil {
+				t.Fatalf("expected nil error, got %+v", err)
+			}
+			sort.Strings(fetched)
+			if !reflect.DeepEqual(fetched, tt.expectedFetched) {
+				t.Errorf("expected fetches %+v, got %+v", tt.expectedFetched, fetched)
+			}
+			for cachePath, linkID := range caches {
+				b, err := os.ReadFile(cachePath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != linkID {
+					t.Errorf("expected shared cache %s to be left untouched, got %q", cachePath, b)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSince is returned when -since is neither a duration nor a date.
+var ErrInvalidSince = errors.New("since must be a duration, such as 24h or 7d, or a date, such as 2006-01-02")
+
+// parseSince parses the cutoff of -since, relative to now. value is either a
+// duration before now, which may also be given in days with a d suffix, or an
+// RFC 3339 timestamp or date in the local time zone.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidSince, value)
+}
+
+// isFileBeforeSince reports whether an input file can be skipped because it
+// was last modified before Since.
+func (a *Archiver) isFileBeforeSince(info os.FileInfo) bool {
+	return !a.Since.IsZero() && info.ModTime().Before(a.Since)
+}
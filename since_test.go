@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		value       string
+		expected    time.Time
+		expectedErr error
+	}{
+		{"24h", time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC), nil},
+		{"90m", time.Date(2024, 3, 10, 10, 30, 0, 0, time.UTC), nil},
+		{"7d", time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC), nil},
+		{"2024-03-01T08:00:00Z", time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC), nil},
+		{"2024-03-01", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local), nil},
+		{"-24h", time.Time{}, ErrInvalidSince},
+		{"last week", time.Time{}, ErrInvalidSince},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.value, func(t *testing.T) {
+			result, err := parseSince(tt.value, now)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %+v, got %+v", tt.expectedErr, err)
+			}
+			if !result.Equal(tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestArchiveSince(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	cutoff := time.Now().Add(-24 * time.Hour)
+	files := []struct {
+		name    string
+		link    string
+		modTime time.Time
+	}{
+		{"old.md", "https://example.com/old", cutoff.Add(-time.Hour)},
+		{"new.md", "https://example.com/new", cutoff.Add(time.Hour)},
+		{"ignored.md", "https://ignored.example.com/new", cutoff.Add(time.Hour)},
+	}
+	for _, f := range files {
+		filePath := filepath.Join(inputDir, f.name)
+		if err := os.WriteFile(filePath, []byte(" [link]("+f.link+")\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filePath, f.modTime, f.modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, ignoreFile), []byte("ignored.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var fetched []string
+	a := &Archiver{
+		InputDir:  inputDir,
+		OutputDir: outputDir,
+		Since:     cutoff,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			fetched = append(fetched, link)
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+	}
+	if err := a.Archive(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	if len(fetched) != 1 || fetched[0] != "https://example.com/new" {
+		t.Errorf("expected only the link in the recently modified file to be fetched, got %+v", fetched)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sitemapFile is the name of the sitemap written to the output directory.
+const sitemapFile = "sitemap.xml"
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// writeSitemap writes a sitemap of the archives in the output directory,
+// with URLs relative to SitemapBaseURL. Archives that only point to a
+// duplicate are left out, since they have no content of their own.
+func (a *Archiver) writeSitemap() error {
+	archives, err := a.listArchives()
+	if err != nil {
+		return err
+	}
+	baseURL := strings.TrimRight(a.SitemapBaseURL, "/")
+	urlSet := sitemapURLSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for linkID, entryPath := range archives {
+		isDir := entryPath != archivePath(a.OutputDir, linkID, formatSingleFile)
+		filePath, metadata, err := archiveFile(a.OutputDir, linkID, isDir)
+		if err != nil || metadata.DuplicateOf != "" {
+			continue
+		}
+		rel, err := filepath.Rel(a.OutputDir, filePath)
+		if err != nil {
+			return err
+		}
+		loc := (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath()
+		urlSet.URLs = append(urlSet.URLs, sitemapURL{
+			Loc:     baseURL + "/" + loc,
+			LastMod: metadata.ArchivedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	sort.Slice(urlSet.URLs, func(i, j int) bool {
+		return urlSet.URLs[i].Loc < urlSet.URLs[j].Loc
+	})
+
+	b, err := xml.MarshalIndent(urlSet, "", "  ")
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.Write(b)
+	buf.WriteString("\n")
+	return writeArchive(a.OutputDir, path.Join(a.OutputDir, sitemapFile), &buf)
+}
+
+// validateSitemapBaseURL reports whether baseURL can be used as the base of
+// absolute sitemap URLs.
+func validateSitemapBaseURL(baseURL string) error {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidSitemapBaseURL, baseURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w %q", ErrInvalidSitemapBaseURL, baseURL)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteSitemap(t *testing.T) {
+	outputDir := t.TempDir()
+	archivedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	archives := map[string]string{
+		archivePath(outputDir, "example.com__a", formatHTML):       "---\nurl: https://example.com/a\narchived_at: 2024-01-02T03:04:05Z\n---\n",
+		archivePath(outputDir, "example.com__b", formatMarkdown):   "---\nurl: https://example.com/b\narchived_at: 2024-01-02T03:04:05Z\n---\n",
+		archivePath(outputDir, "example.com__c", formatSingleFile): "<!--\n---\nurl: https://example.com/c\narchived_at: 2024-01-02T03:04:05Z\n---\n-->\n",
+		archivePath(outputDir, "example.com__d", formatHTML):       "---\nurl: https://example.com/d\narchived_at: 2024-01-02T03:04:05Z\nduplicate_of: example.com__a\n---\n",
+	}
+	for filePath, content := range archives {
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := &Archiver{OutputDir: outputDir, SitemapBaseURL: "https://archive.example.org/links/"}
+	if err := a.writeSitemap(); err != nil {
+		t.Fatalf("expected nil error, got %+v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(outputDir, sitemapFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var urlSet sitemapURLSet
+	if err := xml.Unmarshal(b, &urlSet); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"https://archive.example.org/links/example.com__a/index.html",
+		"https://archive.example.org/links/example.com__b/index.md",
+		"https://archive.example.org/links/example.com__c.html",
+	}
+	if len(urlSet.URLs) != len(expected) {
+		t.Fatalf("expected %d URLs, got %+v", len(expected), urlSet.URLs)
+	}
+	for i, u := range urlSet.URLs {
+		if u.Loc != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], u.Loc)
+		}
+		if u.LastMod != archivedAt.Format(time.RFC3339) {
+			t.Errorf("expected lastmod %+v, got %+v", archivedAt.Format(time.RFC3339), u.LastMod)
+		}
+	}
+}
+
+func TestValidateSitemapBaseURL(t *testing.T) {
+	var tests = []struct {
+		given string
+		valid bool
+	}{
+		{"https://archive.example.org", true},
+		{"http://localhost:8080/links", true},
+		{"archive.example.org", false},
+		{"/links", false},
+		{"ftp://archive.example.org", false},
+	}
+	for _, tt := range tests {
+		err := validateSitemapBaseURL(tt.given)
+		if tt.valid && err != nil {
+			t.Errorf("(%+v): expected nil error, got %+v", tt.given, err)
+		} else if !tt.valid && err == nil {
+			t.Errorf("(%+v): expected error, got nil", tt.given)
+		}
+	}
+}
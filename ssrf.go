@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// ErrPrivateAddress is returned when a request would be sent to a private,
+// loopback or link-local address while AllowPrivateIPs is not set.
+var ErrPrivateAddress = errors.New("refusing to fetch private address")
+
+// privateAddressGuard refuses outbound connections to private addresses, so
+// that links in notes can't be used to reach internal services. Its DialContext
+// resolves hosts itself and connects to the addresses it checked, so that a
+// host can't pass the check and then resolve to a private address when it is
+// dialed. This covers redirects and the images and favicons of archived pages.
+//
+// Requests sent through a proxy are resolved by the proxy, so for those
+// RoundTrip checks the host of the request up front instead, and the proxy
+// itself may be on a private address.
+type privateAddressGuard struct {
+	archiver *Archiver
+	dialer   *net.Dialer
+	next     *http.Transport
+}
+
+// proxiedRequestKey marks the context of a request sent through a proxy,
+// whose connection is to the proxy rather than the host of the request.
+type proxiedRequestKey struct{}
+
+func (g *privateAddressGuard) RoundTrip(req *http.Request) (*http.Response, error) {
+	if g.next.Proxy != nil {
+		proxyURL, err := g.next.Proxy(req)
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL != nil {
+			if err := g.archiver.checkHost(req.Context(), req.URL.Hostname()); err != nil {
+				return nil, err
+			}
+			req = req.WithContext(context.WithValue(req.Context(), proxiedRequestKey{}, true))
+		}
+	}
+	return g.next.RoundTrip(req)
+}
+
+// DialContext connects to addr, refusing to if its host resolves to any
+// private address.
+func (g *privateAddressGuard) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if proxied, _ := ctx.Value(proxiedRequestKey{}).(bool); proxied {
+		return g.dialer.DialContext(ctx, network, addr)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := g.archiver.resolvePublicHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var firstErr error
+	for _, ip := range ips {
+		conn, err := g.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// checkHost resolves host and returns ErrPrivateAddress if any of its
+// addresses are private.
+func (a *Archiver) checkHost(ctx context.Context, host string) error {
+	_, err := a.resolvePublicHost(ctx, host)
+	return err
+}
+
+// resolvePublicHost returns the addresses of host, or ErrPrivateAddress if any
+// of them are private.
+func (a *Archiver) resolvePublicHost(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if isPrivateIP(ip) {
+			return nil, fmt.Errorf("%w %s", ErrPrivateAddress, ip)
+		}
+		return []net.IP{ip}, nil
+	}
+	lookupIP := a.lookupIP
+	if lookupIP == nil {
+		lookupIP = net.DefaultResolver.LookupIPAddr
+	}
+	addrs, err := lookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if isPrivateIP(addr.IP) {
+			return nil, fmt.Errorf("%w %s (%s)", ErrPrivateAddress, addr.IP, host)
+		}
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
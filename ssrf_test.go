@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckHost(t *testing.T) {
+	resolved := map[string][]string{
+		"public.example":     {"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"},
+		"loopback.example":   {"127.0.0.1"},
+		"private.example":    {"93.184.216.34", "192.168.1.10"},
+		"carrier.example":    {"10.1.2.3"},
+		"metadata.example":   {"169.254.169.254"},
+		"ipv6-local.example": {"::1"},
+		"ula.example":        {"fd12:3456:789a::1"},
+	}
+	a := &Archiver{
+		lookupIP: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			ips, ok := resolved[host]
+			if !ok {
+				return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			}
+			var addrs []net.IPAddr
+			for _, ip := range ips {
+				addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+			}
+			return addrs, nil
+		},
+	}
+	var tests = []struct {
+		host     string
+		expected error
+	}{
+		{"public.example", nil},
+		{"loopback.example", ErrPrivateAddress},
+		{"private.example", ErrPrivateAddress},
+		{"carrier.example", ErrPrivateAddress},
+		{"metadata.example", ErrPrivateAddress},
+		{"ipv6-local.example", ErrPrivateAddress},
+		{"ula.example", ErrPrivateAddress},
+		{"169.254.169.254", ErrPrivateAddress},
+		{"172.16.0.1", ErrPrivateAddress},
+		{"93.184.216.34", nil},
+	}
+	for _, tt := range tests {
+		err := a.checkHost(context.Background(), tt.host)
+		if tt.expected == nil && err != nil {
+			t.Errorf("(%+v): expected nil error, got %+v", tt.host, err)
+		} else if !errors.Is(err, tt.expected) {
+			t.Errorf("(%+v): expected %+v, got %+v", tt.host, tt.expected, err)
+		}
+	}
+
+	if err := a.checkHost(context.Background(), "missing.example"); err == nil {
+		t.Error("expected error for unresolvable host, got nil")
+	}
+}
+
+func TestFetchFromURLPrivateAddress(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(testArticleHTML))
+	}))
+	defer server.Close()
+
+	a := &Archiver{}
+	_, err := a.fetchFromURL(context.Background(), server.URL, cacheValidators{})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if requested {
+		t.Error("expected request to a loopback address to be refused")
+	}
+}
+
+func TestFetchFromURLDNSRebinding(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(testArticleHTML))
+	}))
+	defer server.Close()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the host passes a check with its first answer, then resolves to the
+	// server on the loopback address
+	lookups := 0
+	a := &Archiver{
+		lookupIP: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			lookups++
+			if lookups == 1 {
+				return []net.IPAddr{{IP: net.ParseIP("192.0.2.1")}}, nil
+			}
+			return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = a.fetchFromURL(ctx, "http://rebind.example:"+port+"/", cacheValidators{})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if requested {
+		t.Error("expected the loopback address to not be connected to")
+	}
+	// the address that was checked is the one dialed
+	if !strings.Contains(err.Error(), "192.0.2.1") {
+		t.Errorf("expected the checked address to be dialed, got %+v", err)
+	}
+	if lookups != 1 {
+		t.Errorf("expected the host to be resolved once, got %d lookups", lookups)
+	}
+}
+
+func TestFetchFromURLPrivateAddressThroughProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(testArticleHTML))
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the proxy is on a loopback address, but only the hosts of requests
+	// are checked
+	a := &Archiver{
+		Proxy: proxyURL,
+		lookupIP: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			if host == "internal.example" {
+				return []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}, nil
+			}
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+		},
+	}
+	if _, err := a.fetchFromURL(context.Background(), "http://public.example/abc", cacheValidators{}); err != nil {
+		t.Errorf("expected nil error, got %+v", err)
+	}
+	_, err = a.fetchFromURL(context.Background(), "http://internal.example/abc", cacheValidators{})
+	if err == nil || !strings.Contains(err.Error(), ErrPrivateAddress.Error()) {
+		t.Errorf("expected %+v, got %+v", ErrPrivateAddress, err)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVersionString(t *testing.T) {
+	previousVersion, previousCommit, previousBuildDate := version, commit, buildDate
+	defer func() {
+		version, commit, buildDate = previousVersion, previousCommit, previousBuildDate
+	}()
+
+	version, commit, buildDate = "v1.2.3", "abc123", "2021-12-13T00:00:00Z"
+	expected := "archiver v1.2.3 (commit abc123, built 2021-12-13T00:00:00Z)"
+	if result := versionString(); result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+
+	version, commit, buildDate = "", "", ""
+	if result := versionString(); !strings.HasPrefix(result, "archiver ") {
+		t.Errorf("expected fallback version, got %q", result)
+	}
+}
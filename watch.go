@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long to wait after the last change to an input file
+// before archiving it, so that a burst of saves is only processed once.
+const watchDebounce = 500 * time.Millisecond
+
+// Watch archives the input once, then watches the input directory and
+// archives the links in input files as they are created or changed, until
+// ctx is done. An initial run that reaches MaxLinks, or in which links fail
+// with FailOnError set, is logged rather than ending the watch.
+func (a *Archiver) Watch(ctx context.Context) error {
+	if a.InputDir == stdinInput {
+		return errors.New("cannot watch when reading markdown from stdin")
+	}
+	// hold the lock for the whole watch, rather than for each run
+	release, err := a.acquireLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer a.closeBrowser()
+	err = a.ArchiveContext(ctx)
+	if errors.Is(err, ErrMaxLinksReached) || errors.Is(err, ErrLinksFailed) {
+		a.logf(logEvent{Event: eventWarning, Err: err}, "initial archive of %s: %+v", a.InputDir, err)
+	} else if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := addWatchDirs(watcher, a.InputDir); err != nil {
+		return err
+	}
+
+	pending := make(map[string]bool)
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Create) {
+				// fsnotify doesn't watch recursively, so watch new
+				// directories as they appear
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := addWatchDirs(watcher, event.Name); err != nil {
+						a.logf(logEvent{Event: eventWarning, SourceFile: event.Name, Err: err}, "cannot watch %s: %+v", event.Name, err)
+					}
+					continue
+				}
+			}
+			if (event.Has(fsnotify.Create) || event.Has(fsnotify.Write)) && a.isInputFile(event.Name) {
+				pending[event.Name] = true
+				timer.Reset(watchDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			a.logf(logEvent{Event: eventWarning, Err: err}, "cannot watch %s: %+v", a.InputDir, err)
+		case <-timer.C:
+			filePaths := make([]string, 0, len(pending))
+			for filePath := range pending {
+				filePaths = append(filePaths, filePath)
+			}
+			sort.Strings(filePaths)
+			pending = make(map[string]bool)
+			if err := a.archiveChangedFiles(ctx, filePaths); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// archiveChangedFiles archives the links in filePaths, then writes the
+// caches so that the progress is kept if the watch is stopped.
+func (a *Archiver) archiveChangedFiles(ctx context.Context, filePaths []string) error {
+	a.processedLinks = make(map[string]bool)
+	a.ignoreCache = nil
+	a.newLinks = 0
+	if err := a.openBundle(); err != nil {
+		return err
+	}
+	for _, filePath := range filePaths {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			// the file was removed or renamed before it could be archived
+			continue
+		}
+		err = a.processLinksInFile(ctx, filePath)
+		if err != nil && (err == ctx.Err() || err == ErrMaxLinksReached) {
+			break
+		} else if err != nil {
+			a.logf(logEvent{Event: eventFailed, SourceFile: a.relativeInputPath(filePath), Err: err}, "cannot archive links in %s: %+v", filePath, err)
+			continue
+		}
+		a.setFileProcessed(filePath, info)
+	}
+	if err := a.closeBundle(true); err != nil {
+		return err
+	}
+	return a.writeCaches()
+}
+
+// addWatchDirs adds dir and its subdirectories to watcher.
+func addWatchDirs(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return watcher.Add(filePath)
+		}
+		return nil
+	})
+}
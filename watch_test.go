@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestWatch(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	var mu sync.Mutex
+	fetched := make(map[string]int)
+	archived := make(chan string, 10)
+	a := &Archiver{
+		InputDir:  inputDir,
+		OutputDir: outputDir,
+		Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			fetched[link]++
+			return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+		}),
+		OnArchived: func(metadata Metadata, contentPath string) {
+			archived <- metadata.URL
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- a.Watch(ctx)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %+v, got %+v", context.Canceled, err)
+		}
+	}()
+
+	// give the watcher time to start after the initial archive
+	time.Sleep(100 * time.Millisecond)
+
+	// a burst of edits should only be archived once
+	notesPath := filepath.Join(inputDir, "notes.md")
+	for _, markdown := range []string{" [a](https://example.com/a)\n", " [a](https://example.com/a)\n [b](https://example.com/b)\n"} {
+		if err := os.WriteFile(notesPath, []byte(markdown), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := map[string]bool{"https://example.com/a": true, "https://example.com/b": true}
+	for len(expected) > 0 {
+		select {
+		case link := <-archived:
+			delete(expected, link)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for links to be archived, missing %+v", expected)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for link, count := range fetched {
+		if count != 1 {
+			t.Errorf("(%+v): expected 1 fetch, got %d", link, count)
+		}
+	}
+}
+
+func TestWatchContinuesAfterInitialRun(t *testing.T) {
+	var tests = []struct {
+		name     string
+		maxLinks int
+	}{
+		{"max links reached", 1},
+		{"links failed", 0},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			outputDir := t.TempDir()
+			markdown := " [a](https://example.com/a)\n [failed](https://example.com/failed)\n"
+			if err := os.WriteFile(filepath.Join(inputDir, "notes.md"), []byte(markdown), 0644); err != nil {
+				t.Fatal(err)
+			}
+			archived := make(chan string, 10)
+			a := &Archiver{
+				InputDir:    inputDir,
+				OutputDir:   outputDir,
+				MaxLinks:    tt.maxLinks,
+				FailOnError: true,
+				Fetcher: FetcherFunc(func(ctx context.Context, link string) (readability.Article, error) {
+					if link == "https://example.com/failed" {
+						return readability.Article{}, errors.New("fetch failed")
+					}
+					return readability.Article{Title: "Example", Content: "<p>abc</p>"}, nil
+				}),
+				OnArchived: func(metadata Metadata, contentPath string) {
+					archived <- metadata.URL
+				},
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- a.Watch(ctx)
+			}()
+			select {
+			case link := <-archived:
+				if link != "https://example.com/a" {
+					t.Errorf("expected initial run to archive https://example.com/a, got %+v", link)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the initial run")
+			}
+
+			// the watch is still running, and archives new links
+			time.Sleep(100 * time.Millisecond)
+			if err := os.WriteFile(filepath.Join(inputDir, "more.md"), []byte(" [b](https://example.com/b)\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case link := <-archived:
+				if link != "https://example.com/b" {
+					t.Errorf("expected https://example.com/b to be archived, got %+v", link)
+				}
+			case err := <-done:
+				t.Fatalf("expected watch to continue, got %+v", err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the new link")
+			}
+			cancel()
+			if err := <-done; !errors.Is(err, context.Canceled) {
+				t.Errorf("expected %+v, got %+v", context.Canceled, err)
+			}
+		})
+	}
+}